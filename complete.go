@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/bestk/kiro2cc/parser"
+)
+
+// 旧版 Text Completions 的对话标记
+const (
+	humanPrompt     = "\n\nHuman:"
+	assistantPrompt = "\n\nAssistant:"
+)
+
+// completionTurnPattern 匹配 prompt 中的对话标记
+var completionTurnPattern = regexp.MustCompile(`\n\n(Human|Assistant):`)
+
+// CompletionRequest 表示旧版 /v1/complete 的请求结构
+type CompletionRequest struct {
+	Model             string         `json:"model"`
+	Prompt            string         `json:"prompt"`
+	MaxTokensToSample *int           `json:"max_tokens_to_sample"`
+	StopSequences     []string       `json:"stop_sequences,omitempty"`
+	Temperature       *float64       `json:"temperature,omitempty"`
+	TopP              *float64       `json:"top_p,omitempty"`
+	TopK              *int           `json:"top_k,omitempty"`
+	Stream            bool           `json:"stream"`
+	Metadata          map[string]any `json:"metadata,omitempty"`
+}
+
+// toAnthropicRequest 把 "\n\nHuman: ...\n\nAssistant:" 格式的 prompt 解析为消息。
+// 第一个 Human 之前的文本作为 system，最后的 Assistant 有内容时作为预填充
+func (req CompletionRequest) toAnthropicRequest() (AnthropicRequest, error) {
+	anthropicReq := AnthropicRequest{
+		Model:         req.Model,
+		Stream:        req.Stream,
+		StopSequences: req.StopSequences,
+		Temperature:   req.Temperature,
+		TopP:          req.TopP,
+		TopK:          req.TopK,
+		Metadata:      req.Metadata,
+	}
+	if req.MaxTokensToSample == nil {
+		return anthropicReq, &invalidRequestError{"max_tokens_to_sample: field required"}
+	}
+	anthropicReq.MaxTokens = *req.MaxTokensToSample
+
+	turns := completionTurnPattern.FindAllStringSubmatchIndex(req.Prompt, -1)
+	if len(turns) == 0 || req.Prompt[turns[0][2]:turns[0][3]] != "Human" {
+		return anthropicReq, &invalidRequestError{fmt.Sprintf("prompt must start with %q turn after an optional system prompt", humanPrompt)}
+	}
+	if last := turns[len(turns)-1]; req.Prompt[last[2]:last[3]] != "Assistant" {
+		return anthropicReq, &invalidRequestError{fmt.Sprintf("prompt must end with %q turn", assistantPrompt)}
+	}
+
+	if system := strings.TrimSpace(req.Prompt[:turns[0][0]]); system != "" {
+		anthropicReq.System = AnthropicSystem{{Type: "text", Text: system}}
+	}
+	for i, turn := range turns {
+		end := len(req.Prompt)
+		if i+1 < len(turns) {
+			end = turns[i+1][0]
+		}
+		text := strings.TrimSpace(req.Prompt[turn[1]:end])
+
+		role := "user"
+		if req.Prompt[turn[2]:turn[3]] == "Assistant" {
+			role = "assistant"
+			// 末尾空的 Assistant 只表示轮到模型回答
+			if text == "" && i == len(turns)-1 {
+				continue
+			}
+		}
+		anthropicReq.Messages = append(anthropicReq.Messages, AnthropicRequestMessage{Role: role, Content: text})
+	}
+
+	return anthropicReq, nil
+}
+
+// completionStop 把 stop_reason 转换为旧版的 stop_reason 和 stop。
+// 旧版 API 中模型自然结束也报告为 stop_sequence，stop 为 "\n\nHuman:"
+func completionStop(stopReason, stopSequence string) (string, any) {
+	switch stopReason {
+	case "max_tokens":
+		return "max_tokens", nil
+	case "stop_sequence":
+		return "stop_sequence", stopSequence
+	default:
+		return "stop_sequence", humanPrompt
+	}
+}
+
+// completionResponse 构建 completion 对象
+func completionResponse(id, model, completion string, stopReason, stop any) map[string]any {
+	return map[string]any{
+		"type":        "completion",
+		"id":          id,
+		"completion":  completion,
+		"stop_reason": stopReason,
+		"stop":        stop,
+		"model":       model,
+		"log_id":      id,
+	}
+}
+
+// handleComplete 处理旧版 POST /v1/complete，错误格式与 /v1/messages 相同
+func handleComplete(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeErrorResponse(w, parser.InvalidRequestError, "只支持POST请求")
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeErrorResponse(w, parser.InvalidRequestError, fmt.Sprintf("读取请求体失败: %v", err))
+		return
+	}
+	defer r.Body.Close()
+
+	var req CompletionRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeErrorResponse(w, parser.InvalidRequestError, fmt.Sprintf("解析请求体失败: %v", err))
+		return
+	}
+	anthropicReq, err := req.toAnthropicRequest()
+	if err != nil {
+		writeUpstreamError(w, err)
+		return
+	}
+	if _, ok := ModelMap[anthropicReq.Model]; !ok {
+		writeErrorResponse(w, parser.NotFoundError, fmt.Sprintf("model: %s", anthropicReq.Model))
+		return
+	}
+
+	id := "compl_" + strings.ReplaceAll(generateUUID(), "-", "")
+	if req.Stream {
+		streamCompletion(w, id, anthropicReq)
+		return
+	}
+
+	respBody, err := upstreamClient.Call(anthropicReq)
+	if err != nil {
+		writeUpstreamError(w, err)
+		return
+	}
+	anthropicResp, err := buildAnthropicResponse(anthropicReq, respBody)
+	if err != nil {
+		writeUpstreamError(w, err)
+		return
+	}
+
+	var completion strings.Builder
+	for _, block := range anthropicResp["content"].([]map[string]any) {
+		if block["type"] == "text" {
+			completion.WriteString(block["text"].(string))
+		}
+	}
+	stopSequence, _ := anthropicResp["stop_sequence"].(string)
+	stopReason, stop := completionStop(anthropicResp["stop_reason"].(string), stopSequence)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(completionResponse(id, anthropicReq.Model, completion.String(), stopReason, stop))
+}
+
+// streamCompletion 把翻译后的文本增量转换为旧版 completion 事件，最后一个事件带 stop_reason
+func streamCompletion(w http.ResponseWriter, id string, anthropicReq AnthropicRequest) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeErrorResponse(w, parser.APIError, "Streaming unsupported!")
+		return
+	}
+
+	resp, err := upstreamClient.Stream(anthropicReq)
+	if err != nil {
+		writeUpstreamError(w, err)
+		return
+	}
+	defer resp.Body.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	sendSSEEvent(w, flusher, "ping", map[string]string{"type": "ping"})
+
+	translator := newTranslator(anthropicReq)
+	err = translator.Stream(resp.Body, func(e parser.SSEEvent) {
+		if e.Event != "content_block_delta" {
+			return
+		}
+		delta := e.Data.(map[string]interface{})["delta"].(map[string]interface{})
+		if delta["type"] == "text_delta" {
+			sendSSEEvent(w, flusher, "completion", completionResponse(id, anthropicReq.Model, delta["text"].(string), nil, nil))
+		}
+	})
+	if err != nil {
+		fmt.Printf("错误: 读取 CodeWhisperer 响应流失败: %v\n", err)
+		sendErrorEvent(w, flusher, upstreamErrorType(err), err)
+		return
+	}
+
+	stopReason, stop := completionStop(translator.StopReason(), translator.StopSequence())
+	sendSSEEvent(w, flusher, "completion", completionResponse(id, anthropicReq.Model, "", stopReason, stop))
+}
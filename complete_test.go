@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCompletionToAnthropicRequest(t *testing.T) {
+	maxTokens := 256
+	req, err := CompletionRequest{
+		Model:             "claude-2.1",
+		Prompt:            "You are terse.\n\nHuman: Hi\n\nAssistant: Hello.\n\nHuman: Count to three.\n\nAssistant: One,",
+		MaxTokensToSample: &maxTokens,
+	}.toAnthropicRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if req.MaxTokens != 256 || req.System.Text() != "You are terse." {
+		t.Errorf("max_tokens=%d system=%q", req.MaxTokens, req.System.Text())
+	}
+	want := []AnthropicRequestMessage{
+		{Role: "user", Content: "Hi"},
+		{Role: "assistant", Content: "Hello."},
+		{Role: "user", Content: "Count to three."},
+		{Role: "assistant", Content: "One,"},
+	}
+	if !reflect.DeepEqual(req.Messages, want) {
+		t.Errorf("messages:\ngot  %#v\nwant %#v", req.Messages, want)
+	}
+
+	// 末尾空的 Assistant 不产生消息
+	req, _ = CompletionRequest{Prompt: "\n\nHuman: Hi\n\nAssistant:", MaxTokensToSample: &maxTokens}.toAnthropicRequest()
+	if !reflect.DeepEqual(req.Messages, []AnthropicRequestMessage{{Role: "user", Content: "Hi"}}) {
+		t.Errorf("messages = %#v", req.Messages)
+	}
+
+	for _, prompt := range []string{"Hi", "\n\nAssistant: Hi\n\nHuman: there\n\nAssistant:", "\n\nHuman: Hi"} {
+		if _, err := (CompletionRequest{Prompt: prompt, MaxTokensToSample: &maxTokens}).toAnthropicRequest(); err == nil {
+			t.Errorf("prompt %q: expected an error", prompt)
+		}
+	}
+	if _, err := (CompletionRequest{Prompt: "\n\nHuman: Hi\n\nAssistant:"}).toAnthropicRequest(); err == nil {
+		t.Error("missing max_tokens_to_sample: expected an error")
+	}
+}
+
+func TestComplete(t *testing.T) {
+	withMockUpstream(t)
+
+	body := `{"model":"claude-sonnet-4-20250514","max_tokens_to_sample":100,"prompt":"\n\nHuman: hello\n\nAssistant:"}`
+	rec := httptest.NewRecorder()
+	handleComplete(rec, httptest.NewRequest(http.MethodPost, "/v1/complete", strings.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body)
+	}
+
+	var resp map[string]any
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp["type"] != "completion" || resp["completion"] != "Mock response to: hello" {
+		t.Errorf("unexpected response: %s", rec.Body)
+	}
+	if resp["stop_reason"] != "stop_sequence" || resp["stop"] != humanPrompt {
+		t.Errorf("stop_reason=%v stop=%q", resp["stop_reason"], resp["stop"])
+	}
+}
+
+func TestCompleteStream(t *testing.T) {
+	withMockUpstream(t)
+
+	body := `{"model":"claude-sonnet-4-20250514","max_tokens_to_sample":100,"stream":true,"prompt":"\n\nHuman: hello\n\nAssistant:"}`
+	rec := httptest.NewRecorder()
+	handleComplete(rec, httptest.NewRequest(http.MethodPost, "/v1/complete", strings.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body)
+	}
+
+	var completion strings.Builder
+	var events []map[string]any
+	scanner := bufio.NewScanner(rec.Body)
+	for scanner.Scan() {
+		line, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		var event map[string]any
+		if err := json.Unmarshal([]byte(line), &event); err != nil {
+			t.Fatalf("bad event %q: %v", line, err)
+		}
+		if event["type"] == "completion" {
+			completion.WriteString(event["completion"].(string))
+			events = append(events, event)
+		}
+	}
+
+	if completion.String() != "Mock response to: hello" {
+		t.Errorf("completion = %q", completion.String())
+	}
+	for _, event := range events[:len(events)-1] {
+		if event["stop_reason"] != nil {
+			t.Errorf("intermediate event has stop_reason: %v", event)
+		}
+	}
+	if last := events[len(events)-1]; last["stop_reason"] != "stop_sequence" || last["completion"] != "" {
+		t.Errorf("last event = %v", last)
+	}
+}
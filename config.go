@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"os"
 	"time"
+
+	"github.com/bestk/kiro2cc/parser"
 )
 
 // Config 应用配置
@@ -37,12 +39,35 @@ type Config struct {
 		RefreshThreshold time.Duration `json:"refresh_threshold"`
 	} `json:"token"`
 
+	// 事件流解析配置
+	Parser struct {
+		MaxFrameSize int `json:"max_frame_size"`
+	} `json:"parser"`
+
+	// 扩展思考配置，unsupported_policy 为 error 或 degrade
+	Thinking struct {
+		UnsupportedPolicy string `json:"unsupported_policy"`
+	} `json:"thinking"`
+
 	// API配置
 	API struct {
-		CodeWhispererURL string `json:"codewhisperer_url"`
-		KiroAuthURL      string `json:"kiro_auth_url"`
+		Region           string `json:"region"`            // 上游区域，未配置URL时用于拼接官方地址
+		CodeWhispererURL string `json:"codewhisperer_url"` // 为空时使用区域默认地址
+		KiroAuthURL      string `json:"kiro_auth_url"`     // 为空时使用区域默认地址
 		ProfileArn       string `json:"profile_arn"`
+		ProxyURL         string `json:"proxy_url"` // 出口代理，为空时读取HTTPS_PROXY等环境变量
 	} `json:"api"`
+
+	// 本地模拟上游配置，启用后请求发往 kiro2cc mock-upstream，且不读取本地token
+	MockUpstream struct {
+		Enabled bool   `json:"enabled"`
+		URL     string `json:"url"`
+	} `json:"mock_upstream"`
+
+	// Ollama 兼容端点配置，启用后额外提供 /api/chat、/api/generate 和 /api/tags
+	Ollama struct {
+		Enabled bool `json:"enabled"`
+	} `json:"ollama"`
 }
 
 var config = &Config{}
@@ -67,10 +92,15 @@ func init() {
 	config.Token.CacheTimeout = 5 * time.Minute
 	config.Token.RefreshThreshold = 10 * time.Minute
 
-	config.API.CodeWhispererURL = "https://codewhisperer.us-east-1.amazonaws.com/generateAssistantResponse"
-	config.API.KiroAuthURL = "https://prod.us-east-1.auth.desktop.kiro.dev/refreshToken"
+	config.Parser.MaxFrameSize = parser.DefaultMaxFrameSize
+
+	config.Thinking.UnsupportedPolicy = ThinkingPolicyDegrade
+
+	config.API.Region = "us-east-1"
 	config.API.ProfileArn = "arn:aws:codewhisperer:us-east-1:699475941385:profile/EHGA3GRVQMUK"
 
+	config.MockUpstream.URL = "http://localhost:8081/generateAssistantResponse"
+
 	// 尝试从配置文件加载
 	loadConfigFromFile()
 }
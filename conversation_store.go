@@ -0,0 +1,79 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// StoredResponse 保存的一次 Responses API 响应，previous_response_id 据此续接对话
+type StoredResponse struct {
+	Response  map[string]any            // 返回给客户端的 response 对象
+	Messages  []AnthropicRequestMessage // 截至本次输出的完整对话，已转换为 Anthropic 消息
+	CreatedAt time.Time
+}
+
+// ConversationStore 本地保存的 Responses API 对话，只存在于内存中，重启后丢失
+type ConversationStore struct {
+	mu      sync.Mutex
+	entries map[string]*StoredResponse
+	maxSize int
+	ttl     time.Duration
+}
+
+var conversationStore = &ConversationStore{
+	entries: make(map[string]*StoredResponse),
+	maxSize: 1000,           // 最多保存的响应数
+	ttl:     24 * time.Hour, // 保存时长
+}
+
+// Get 按 response id 取出保存的响应，过期时视为不存在
+func (cs *ConversationStore) Get(id string) (*StoredResponse, bool) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	entry, ok := cs.entries[id]
+	if !ok {
+		return nil, false
+	}
+	if time.Since(entry.CreatedAt) > cs.ttl {
+		delete(cs.entries, id)
+		return nil, false
+	}
+	return entry, true
+}
+
+// Set 保存响应，超过容量时淘汰最早的一条
+func (cs *ConversationStore) Set(id string, entry *StoredResponse) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	if _, exists := cs.entries[id]; !exists && len(cs.entries) >= cs.maxSize {
+		cs.evictOldest()
+	}
+	cs.entries[id] = entry
+}
+
+// Delete 删除保存的响应，返回是否存在
+func (cs *ConversationStore) Delete(id string) bool {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	_, ok := cs.entries[id]
+	delete(cs.entries, id)
+	return ok
+}
+
+// evictOldest 淘汰最早保存的响应，调用方持有锁
+func (cs *ConversationStore) evictOldest() {
+	var oldestID string
+	var oldestTime time.Time
+	for id, entry := range cs.entries {
+		if oldestID == "" || entry.CreatedAt.Before(oldestTime) {
+			oldestID = id
+			oldestTime = entry.CreatedAt
+		}
+	}
+	if oldestID != "" {
+		delete(cs.entries, oldestID)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/bestk/kiro2cc/parser"
+)
+
+// handleCountTokens 处理 POST /v1/messages/count_tokens，用本地 tokenizer 估算输入 token 数，不访问上游
+func handleCountTokens(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeErrorResponse(w, parser.InvalidRequestError, "只支持POST请求")
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeErrorResponse(w, parser.InvalidRequestError, fmt.Sprintf("读取请求体失败: %v", err))
+		return
+	}
+	defer r.Body.Close()
+
+	var anthropicReq AnthropicRequest
+	if err := json.Unmarshal(body, &anthropicReq); err != nil {
+		writeErrorResponse(w, parser.InvalidRequestError, fmt.Sprintf("解析请求体失败: %v", err))
+		return
+	}
+	if err := validateCountTokensRequest(anthropicReq); err != nil {
+		writeUpstreamError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"input_tokens": countInputTokens(anthropicReq),
+	})
+}
+
+// validateCountTokensRequest 按 /v1/messages 的规则校验请求，max_tokens 不是必填项
+func validateCountTokensRequest(req AnthropicRequest) error {
+	if req.Model == "" {
+		return &invalidRequestError{"model: field required"}
+	}
+	if _, ok := ModelMap[req.Model]; !ok {
+		return &invalidRequestError{fmt.Sprintf("model: unknown or unsupported model %q", req.Model)}
+	}
+	if _, err := normalizeMessages(req.Messages); err != nil {
+		return err
+	}
+	if err := validateThinking(req); err != nil {
+		return err
+	}
+	return validateRequestParams(req)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandleCountTokens(t *testing.T) {
+	body := `{
+		"model": "claude-sonnet-4-20250514",
+		"system": "You are terse.",
+		"messages": [{"role": "user", "content": "Count me"}],
+		"tools": [{"name": "Read", "description": "Read a file", "input_schema": {"type": "object"}}]
+	}`
+	var req AnthropicRequest
+	if err := json.Unmarshal([]byte(body), &req); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	handleCountTokens(rec, httptest.NewRequest(http.MethodPost, "/v1/messages/count_tokens", strings.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body)
+	}
+
+	var resp map[string]int
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if want := countInputTokens(req); resp["input_tokens"] != want || len(resp) != 1 {
+		t.Errorf("response = %v, want {input_tokens: %d}", resp, want)
+	}
+}
+
+func TestHandleCountTokensErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"malformed", `{"model":`},
+		{"missing model", `{"messages":[{"role":"user","content":"hi"}]}`},
+		{"unknown model", `{"model":"gpt-4","messages":[{"role":"user","content":"hi"}]}`},
+		{"no messages", `{"model":"claude-sonnet-4-20250514","messages":[]}`},
+	}
+
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		handleCountTokens(rec, httptest.NewRequest(http.MethodPost, "/v1/messages/count_tokens", strings.NewReader(tt.body)))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", tt.name, rec.Code)
+		}
+		if !strings.Contains(rec.Body.String(), `"invalid_request_error"`) {
+			t.Errorf("%s: body = %s, want an invalid_request_error", tt.name, rec.Body)
+		}
+	}
+}
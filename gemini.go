@@ -0,0 +1,499 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/bestk/kiro2cc/parser"
+)
+
+// GeminiRequest 表示 Gemini generateContent 和 streamGenerateContent 的请求结构
+type GeminiRequest struct {
+	Contents          []GeminiContent         `json:"contents"`
+	SystemInstruction *GeminiContent          `json:"systemInstruction,omitempty"`
+	Tools             []GeminiTool            `json:"tools,omitempty"`
+	ToolConfig        *GeminiToolConfig       `json:"toolConfig,omitempty"`
+	GenerationConfig  *GeminiGenerationConfig `json:"generationConfig,omitempty"`
+}
+
+// GeminiContent 表示一条消息，role 为 user 或 model
+type GeminiContent struct {
+	Role  string       `json:"role,omitempty"`
+	Parts []GeminiPart `json:"parts"`
+}
+
+// GeminiPart 表示消息中的一段内容，每段只设置其中一个字段
+type GeminiPart struct {
+	Text             *string                 `json:"text,omitempty"`
+	InlineData       *GeminiBlob             `json:"inlineData,omitempty"`
+	FileData         *GeminiFileData         `json:"fileData,omitempty"`
+	FunctionCall     *GeminiFunctionCall     `json:"functionCall,omitempty"`
+	FunctionResponse *GeminiFunctionResponse `json:"functionResponse,omitempty"`
+}
+
+// GeminiBlob 表示内联的 base64 数据
+type GeminiBlob struct {
+	MimeType string `json:"mimeType"`
+	Data     string `json:"data"`
+}
+
+// GeminiFileData 表示通过 URI 引用的文件
+type GeminiFileData struct {
+	MimeType string `json:"mimeType,omitempty"`
+	FileUri  string `json:"fileUri"`
+}
+
+// GeminiFunctionCall 表示模型发起的函数调用，id 只有较新的客户端会带
+type GeminiFunctionCall struct {
+	Id   string         `json:"id,omitempty"`
+	Name string         `json:"name"`
+	Args map[string]any `json:"args"`
+}
+
+// GeminiFunctionResponse 表示函数执行结果
+type GeminiFunctionResponse struct {
+	Id       string         `json:"id,omitempty"`
+	Name     string         `json:"name"`
+	Response map[string]any `json:"response"`
+}
+
+// GeminiTool 表示 tools 中的一项，只支持 functionDeclarations
+type GeminiTool struct {
+	FunctionDeclarations []OpenAIFunction `json:"functionDeclarations,omitempty"`
+}
+
+// GeminiToolConfig 表示 toolConfig
+type GeminiToolConfig struct {
+	FunctionCallingConfig *struct {
+		Mode                 string   `json:"mode,omitempty"` // AUTO、ANY、NONE 或 VALIDATED
+		AllowedFunctionNames []string `json:"allowedFunctionNames,omitempty"`
+	} `json:"functionCallingConfig,omitempty"`
+}
+
+// GeminiGenerationConfig 表示 generationConfig 中可以转发的参数
+type GeminiGenerationConfig struct {
+	Temperature      *float64       `json:"temperature,omitempty"`
+	TopP             *float64       `json:"topP,omitempty"`
+	TopK             *int           `json:"topK,omitempty"`
+	MaxOutputTokens  *int           `json:"maxOutputTokens,omitempty"`
+	StopSequences    []string       `json:"stopSequences,omitempty"`
+	CandidateCount   *int           `json:"candidateCount,omitempty"`
+	ResponseMimeType string         `json:"responseMimeType,omitempty"`
+	ResponseSchema   map[string]any `json:"responseSchema,omitempty"`
+}
+
+// geminiFinishReasons Anthropic stop_reason 到 Gemini finishReason 的映射
+var geminiFinishReasons = map[string]string{
+	"end_turn":      "STOP",
+	"stop_sequence": "STOP",
+	"tool_use":      "STOP",
+	"max_tokens":    "MAX_TOKENS",
+}
+
+// geminiStatuses Anthropic 错误类型到 Gemini 错误状态码和 status 的映射
+var geminiStatuses = map[string]struct {
+	code   int
+	status string
+}{
+	parser.InvalidRequestError: {http.StatusBadRequest, "INVALID_ARGUMENT"},
+	parser.AuthenticationError: {http.StatusUnauthorized, "UNAUTHENTICATED"},
+	parser.PermissionError:     {http.StatusForbidden, "PERMISSION_DENIED"},
+	parser.NotFoundError:       {http.StatusNotFound, "NOT_FOUND"},
+	parser.RateLimitError:      {http.StatusTooManyRequests, "RESOURCE_EXHAUSTED"},
+	parser.APIError:            {http.StatusInternalServerError, "INTERNAL"},
+	parser.OverloadedError:     {http.StatusServiceUnavailable, "UNAVAILABLE"},
+}
+
+// toAnthropicRequest 把 Gemini 请求转换为 AnthropicRequest。
+// Gemini 的函数调用通常没有 id，按顺序生成，functionResponse 按 id、函数名或调用顺序对应
+func (req GeminiRequest) toAnthropicRequest(model string, stream bool) (AnthropicRequest, error) {
+	anthropicReq := AnthropicRequest{Model: model, Stream: stream}
+
+	if cfg := req.GenerationConfig; cfg != nil {
+		if cfg.CandidateCount != nil && *cfg.CandidateCount != 1 {
+			return anthropicReq, &invalidRequestError{"generationConfig.candidateCount: only 1 candidate is supported"}
+		}
+		if cfg.Temperature != nil {
+			// Gemini 的取值范围是 0 到 2，Anthropic 是 0 到 1
+			temperature := *cfg.Temperature / 2
+			anthropicReq.Temperature = &temperature
+		}
+		anthropicReq.TopP = cfg.TopP
+		anthropicReq.TopK = cfg.TopK
+		if cfg.MaxOutputTokens != nil {
+			anthropicReq.MaxTokens = *cfg.MaxOutputTokens
+		}
+		anthropicReq.StopSequences = cfg.StopSequences
+	}
+
+	var systemParts []string
+	if req.SystemInstruction != nil {
+		systemParts = append(systemParts, geminiText(req.SystemInstruction.Parts))
+	}
+	if cfg := req.GenerationConfig; cfg != nil && (cfg.ResponseMimeType == "application/json" || cfg.ResponseSchema != nil) {
+		systemParts = append(systemParts, jsonResponseInstruction(cfg.ResponseSchema))
+	}
+	if text := strings.Join(systemParts, "\n\n"); text != "" {
+		anthropicReq.System = AnthropicSystem{{Type: "text", Text: text}}
+	}
+
+	if err := req.applyTools(&anthropicReq); err != nil {
+		return anthropicReq, err
+	}
+
+	var callIDs toolCallIDs
+	for i, content := range req.Contents {
+		var blocks []interface{}
+		switch content.Role {
+		case "", "user":
+			for j, part := range content.Parts {
+				block, err := geminiUserBlock(part, &callIDs)
+				if err != nil {
+					return anthropicReq, fmt.Errorf("contents.%d.parts.%d.%w", i, j, err)
+				}
+				blocks = append(blocks, block)
+			}
+			anthropicReq.Messages = append(anthropicReq.Messages, AnthropicRequestMessage{Role: "user", Content: blocks})
+
+		case "model":
+			callIDs.reset()
+			for j, part := range content.Parts {
+				switch {
+				case part.Text != nil:
+					blocks = append(blocks, map[string]interface{}{"type": "text", "text": *part.Text})
+				case part.FunctionCall != nil:
+					input := part.FunctionCall.Args
+					if input == nil {
+						input = map[string]any{}
+					}
+					id := callIDs.add(part.FunctionCall.Id, part.FunctionCall.Name)
+					blocks = append(blocks, map[string]interface{}{"type": "tool_use", "id": id, "name": part.FunctionCall.Name, "input": input})
+				default:
+					return anthropicReq, &invalidRequestError{fmt.Sprintf("contents.%d.parts.%d: model turns may only contain text and functionCall parts", i, j)}
+				}
+			}
+			anthropicReq.Messages = append(anthropicReq.Messages, AnthropicRequestMessage{Role: "assistant", Content: blocks})
+
+		default:
+			return anthropicReq, &invalidRequestError{fmt.Sprintf("contents.%d.role: unexpected role %q, expected user or model", i, content.Role)}
+		}
+	}
+
+	return anthropicReq, nil
+}
+
+// applyTools 转换 functionDeclarations 和 toolConfig。
+// ANY 只允许一个函数时对应 tool_choice tool，允许多个时只转发这些函数
+func (req GeminiRequest) applyTools(anthropicReq *AnthropicRequest) error {
+	for _, tool := range req.Tools {
+		for _, fn := range tool.FunctionDeclarations {
+			anthropicReq.Tools = append(anthropicReq.Tools, openAIFunctionTool(fn))
+		}
+	}
+	if req.ToolConfig == nil || req.ToolConfig.FunctionCallingConfig == nil {
+		return nil
+	}
+
+	cfg := req.ToolConfig.FunctionCallingConfig
+	switch cfg.Mode {
+	case "", "AUTO", "VALIDATED":
+		anthropicReq.ToolChoice = &AnthropicToolChoice{Type: ToolChoiceAuto}
+	case "NONE":
+		anthropicReq.ToolChoice = &AnthropicToolChoice{Type: ToolChoiceNone}
+	case "ANY":
+		anthropicReq.ToolChoice = &AnthropicToolChoice{Type: ToolChoiceAny}
+		if len(cfg.AllowedFunctionNames) == 1 {
+			anthropicReq.ToolChoice = &AnthropicToolChoice{Type: ToolChoiceTool, Name: cfg.AllowedFunctionNames[0]}
+		}
+	default:
+		return &invalidRequestError{fmt.Sprintf("toolConfig.functionCallingConfig.mode: unsupported value %q", cfg.Mode)}
+	}
+
+	if len(cfg.AllowedFunctionNames) > 1 {
+		var allowed []AnthropicTool
+		for _, tool := range anthropicReq.Tools {
+			for _, name := range cfg.AllowedFunctionNames {
+				if tool.Name == name {
+					allowed = append(allowed, tool)
+				}
+			}
+		}
+		anthropicReq.Tools = allowed
+	}
+	return nil
+}
+
+// geminiUserBlock 把 user 消息中的一段内容转换为 Anthropic 内容块
+func geminiUserBlock(part GeminiPart, callIDs *toolCallIDs) (map[string]interface{}, error) {
+	switch {
+	case part.Text != nil:
+		return map[string]interface{}{"type": "text", "text": *part.Text}, nil
+
+	case part.InlineData != nil:
+		if !strings.HasPrefix(part.InlineData.MimeType, "image/") {
+			return nil, &invalidRequestError{fmt.Sprintf("inlineData.mimeType: unsupported type %q, only images are supported", part.InlineData.MimeType)}
+		}
+		return map[string]interface{}{"type": "image", "source": map[string]interface{}{
+			"type": "base64", "media_type": part.InlineData.MimeType, "data": part.InlineData.Data,
+		}}, nil
+
+	case part.FileData != nil:
+		if part.FileData.MimeType != "" && !strings.HasPrefix(part.FileData.MimeType, "image/") {
+			return nil, &invalidRequestError{fmt.Sprintf("fileData.mimeType: unsupported type %q, only images are supported", part.FileData.MimeType)}
+		}
+		return map[string]interface{}{"type": "image", "source": map[string]interface{}{"type": "url", "url": part.FileData.FileUri}}, nil
+
+	case part.FunctionResponse != nil:
+		id, ok := callIDs.match(part.FunctionResponse.Id, part.FunctionResponse.Name)
+		if !ok {
+			return nil, &invalidRequestError{fmt.Sprintf("functionResponse: no preceding functionCall for %q", part.FunctionResponse.Name)}
+		}
+		response, _ := json.Marshal(part.FunctionResponse.Response)
+		return map[string]interface{}{"type": "tool_result", "tool_use_id": id, "content": string(response)}, nil
+	}
+	return nil, &invalidRequestError{"part: must set one of text, inlineData, fileData or functionResponse"}
+}
+
+// geminiText 拼接内容中的文本段
+func geminiText(parts []GeminiPart) string {
+	var texts []string
+	for _, part := range parts {
+		if part.Text != nil {
+			texts = append(texts, *part.Text)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+// geminiResponse 构建一个 GenerateContentResponse，finishReason 和 usage 只在最后一个响应中出现
+func geminiResponse(model string, parts []GeminiPart, finishReason string, usage map[string]any) map[string]any {
+	if parts == nil {
+		parts = []GeminiPart{}
+	}
+	candidate := map[string]any{
+		"content": GeminiContent{Role: "model", Parts: parts},
+		"index":   0,
+	}
+	if finishReason != "" {
+		candidate["finishReason"] = finishReason
+	}
+	resp := map[string]any{
+		"candidates":   []map[string]any{candidate},
+		"modelVersion": model,
+	}
+	if usage != nil {
+		prompt, _ := usage["input_tokens"].(int)
+		candidates, _ := usage["output_tokens"].(int)
+		resp["usageMetadata"] = map[string]any{
+			"promptTokenCount":     prompt,
+			"candidatesTokenCount": candidates,
+			"totalTokenCount":      prompt + candidates,
+		}
+	}
+	return resp
+}
+
+// textPart 构建文本段
+func textPart(text string) GeminiPart {
+	return GeminiPart{Text: &text}
+}
+
+// functionCallPart 从 tool_use 构建 functionCall 段
+func functionCallPart(id, name string, input any) GeminiPart {
+	args, _ := input.(map[string]any)
+	if args == nil {
+		args = map[string]any{}
+	}
+	return GeminiPart{FunctionCall: &GeminiFunctionCall{Id: id, Name: name, Args: args}}
+}
+
+// handleGemini 处理 POST /v1beta/models/{model}:generateContent 和 :streamGenerateContent
+func handleGemini(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeGeminiError(w, parser.InvalidRequestError, "只支持POST请求")
+		return
+	}
+
+	model, method, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/v1beta/models/"), ":")
+	if method != "generateContent" && method != "streamGenerateContent" {
+		writeGeminiError(w, parser.NotFoundError, fmt.Sprintf("method %q is not supported", method))
+		return
+	}
+	if _, ok := ModelMap[model]; !ok {
+		writeGeminiError(w, parser.NotFoundError, fmt.Sprintf("models/%s is not found", model))
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeGeminiError(w, parser.InvalidRequestError, fmt.Sprintf("读取请求体失败: %v", err))
+		return
+	}
+	defer r.Body.Close()
+
+	var req GeminiRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeGeminiError(w, parser.InvalidRequestError, fmt.Sprintf("解析请求体失败: %v", err))
+		return
+	}
+	stream := method == "streamGenerateContent"
+	anthropicReq, err := req.toAnthropicRequest(model, stream)
+	if err != nil {
+		writeGeminiError(w, upstreamErrorType(err), err.Error())
+		return
+	}
+
+	if stream {
+		streamGeminiResponse(w, anthropicReq, r.URL.Query().Get("alt") == "sse")
+		return
+	}
+
+	respBody, err := upstreamClient.Call(anthropicReq)
+	if err != nil {
+		writeGeminiError(w, upstreamErrorType(err), err.Error())
+		return
+	}
+	anthropicResp, err := buildAnthropicResponse(anthropicReq, respBody)
+	if err != nil {
+		writeGeminiError(w, upstreamErrorType(err), err.Error())
+		return
+	}
+
+	var parts []GeminiPart
+	for _, block := range anthropicResp["content"].([]map[string]any) {
+		switch block["type"] {
+		case "text":
+			parts = append(parts, textPart(block["text"].(string)))
+		case "tool_use":
+			parts = append(parts, functionCallPart(block["id"].(string), block["name"].(string), block["input"]))
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(geminiResponse(model, parts, geminiFinishReasons[anthropicResp["stop_reason"].(string)], anthropicResp["usage"].(map[string]any)))
+}
+
+// streamGeminiResponse 把翻译后的 Anthropic 事件转换为 GenerateContentResponse 序列。
+// alt=sse 时按 SSE 输出，否则与 Gemini 一样输出逐步写出的 JSON 数组；函数调用在参数完整后输出
+func streamGeminiResponse(w http.ResponseWriter, anthropicReq AnthropicRequest, sse bool) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeGeminiError(w, parser.APIError, "Streaming unsupported!")
+		return
+	}
+
+	resp, err := upstreamClient.Stream(anthropicReq)
+	if err != nil {
+		writeGeminiError(w, upstreamErrorType(err), err.Error())
+		return
+	}
+	defer resp.Body.Close()
+
+	if sse {
+		w.Header().Set("Content-Type", "text/event-stream")
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	first := true
+	send := func(data map[string]any) {
+		payload, err := json.Marshal(data)
+		if err != nil {
+			return
+		}
+		switch {
+		case sse:
+			fmt.Fprintf(w, "data: %s\r\n\r\n", payload)
+		case first:
+			fmt.Fprintf(w, "[%s", payload)
+		default:
+			fmt.Fprintf(w, ",\r\n%s", payload)
+		}
+		first = false
+		flusher.Flush()
+	}
+	defer func() {
+		if !sse {
+			if first {
+				fmt.Fprint(w, "[")
+			}
+			fmt.Fprint(w, "]")
+			flusher.Flush()
+		}
+	}()
+
+	type toolBlock struct {
+		id, name string
+		input    strings.Builder
+	}
+	tools := map[int]*toolBlock{}
+	translator := newTranslator(anthropicReq)
+	err = translator.Stream(resp.Body, func(e parser.SSEEvent) {
+		data, _ := e.Data.(map[string]interface{})
+		index, _ := data["index"].(int)
+		switch e.Event {
+		case "content_block_start":
+			block := data["content_block"].(map[string]interface{})
+			if block["type"] == "tool_use" {
+				tools[index] = &toolBlock{id: block["id"].(string), name: block["name"].(string)}
+			}
+		case "content_block_delta":
+			delta := data["delta"].(map[string]interface{})
+			switch delta["type"] {
+			case "text_delta":
+				send(geminiResponse(anthropicReq.Model, []GeminiPart{textPart(delta["text"].(string))}, "", nil))
+			case "input_json_delta":
+				if tool, ok := tools[index]; ok {
+					tool.input.WriteString(delta["partial_json"].(string))
+				}
+			}
+		case "content_block_stop":
+			tool, ok := tools[index]
+			if !ok {
+				return
+			}
+			delete(tools, index)
+			input := map[string]any{}
+			json.Unmarshal([]byte(tool.input.String()), &input)
+			send(geminiResponse(anthropicReq.Model, []GeminiPart{functionCallPart(tool.id, tool.name, input)}, "", nil))
+		}
+	})
+	if err == nil {
+		err = checkToolChoice(anthropicReq, translator.StopReason())
+	}
+	if err != nil {
+		fmt.Printf("错误: 读取 CodeWhisperer 响应流失败: %v\n", err)
+		send(geminiError(upstreamErrorType(err), err.Error()))
+		return
+	}
+
+	usage := buildUsage(countInputTokens(anthropicReq), translator)
+	send(geminiResponse(anthropicReq.Model, []GeminiPart{textPart("")}, geminiFinishReasons[translator.StopReason()], usage))
+}
+
+// geminiError 构建 Gemini 格式的错误对象
+func geminiError(errorType, message string) map[string]any {
+	status, ok := geminiStatuses[errorType]
+	if !ok {
+		status = geminiStatuses[parser.APIError]
+	}
+	return map[string]any{
+		"error": map[string]any{
+			"code":    status.code,
+			"message": message,
+			"status":  status.status,
+		},
+	}
+}
+
+// writeGeminiError 以 Gemini 错误格式返回 JSON 错误响应
+func writeGeminiError(w http.ResponseWriter, errorType, message string) {
+	resp := geminiError(errorType, message)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(resp["error"].(map[string]any)["code"].(int))
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGeminiToAnthropicRequest(t *testing.T) {
+	body := `{
+		"systemInstruction": {"parts": [{"text": "Be brief."}]},
+		"generationConfig": {"temperature": 1.0, "maxOutputTokens": 100, "stopSequences": ["END"], "responseMimeType": "application/json"},
+		"tools": [{"functionDeclarations": [
+			{"name": "get_weather", "parameters": {"type": "object"}},
+			{"name": "get_time", "parameters": {"type": "object"}}
+		]}],
+		"toolConfig": {"functionCallingConfig": {"mode": "ANY", "allowedFunctionNames": ["get_weather"]}},
+		"contents": [
+			{"role": "user", "parts": [{"text": "Weather?"}, {"inlineData": {"mimeType": "image/png", "data": "iVBORw0KGgo="}}]},
+			{"role": "model", "parts": [{"functionCall": {"name": "get_weather", "args": {"city": "Paris"}}}]},
+			{"role": "user", "parts": [{"functionResponse": {"name": "get_weather", "response": {"result": "sunny"}}}]}
+		]
+	}`
+	var geminiReq GeminiRequest
+	if err := json.Unmarshal([]byte(body), &geminiReq); err != nil {
+		t.Fatal(err)
+	}
+	req, err := geminiReq.toAnthropicRequest("claude-sonnet-4-20250514", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if req.MaxTokens != 100 || *req.Temperature != 0.5 || !reflect.DeepEqual(req.StopSequences, []string{"END"}) {
+		t.Errorf("generationConfig: max_tokens=%d temperature=%v stop=%v", req.MaxTokens, *req.Temperature, req.StopSequences)
+	}
+	if !strings.HasPrefix(req.System.Text(), "Be brief.\n\n") || !strings.Contains(req.System.Text(), "JSON") {
+		t.Errorf("system = %q", req.System.Text())
+	}
+	if len(req.Tools) != 2 || req.ToolChoice == nil || req.ToolChoice.Type != ToolChoiceTool || req.ToolChoice.Name != "get_weather" {
+		t.Errorf("tools = %+v, tool_choice = %+v", req.Tools, req.ToolChoice)
+	}
+
+	want := []AnthropicRequestMessage{
+		{Role: "user", Content: []interface{}{
+			map[string]interface{}{"type": "text", "text": "Weather?"},
+			map[string]interface{}{"type": "image", "source": map[string]interface{}{"type": "base64", "media_type": "image/png", "data": "iVBORw0KGgo="}},
+		}},
+		{Role: "assistant", Content: []interface{}{
+			map[string]interface{}{"type": "tool_use", "id": "call_1", "name": "get_weather", "input": map[string]any{"city": "Paris"}},
+		}},
+		{Role: "user", Content: []interface{}{
+			map[string]interface{}{"type": "tool_result", "tool_use_id": "call_1", "content": `{"result":"sunny"}`},
+		}},
+	}
+	if !reflect.DeepEqual(req.Messages, want) {
+		t.Errorf("messages:\ngot  %#v\nwant %#v", req.Messages, want)
+	}
+}
+
+func TestGeminiGenerateContent(t *testing.T) {
+	withMockUpstream(t)
+
+	body := `{"contents":[{"role":"user","parts":[{"text":"hello"}]}]}`
+	rec := httptest.NewRecorder()
+	handleGemini(rec, httptest.NewRequest(http.MethodPost, "/v1beta/models/claude-sonnet-4-20250514:generateContent", strings.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body)
+	}
+
+	var resp struct {
+		Candidates []struct {
+			Content      GeminiContent `json:"content"`
+			FinishReason string        `json:"finishReason"`
+		} `json:"candidates"`
+		UsageMetadata map[string]int `json:"usageMetadata"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Candidates) != 1 || geminiText(resp.Candidates[0].Content.Parts) != "Mock response to: hello" {
+		t.Fatalf("unexpected response: %s", rec.Body)
+	}
+	if resp.Candidates[0].Content.Role != "model" || resp.Candidates[0].FinishReason != "STOP" {
+		t.Errorf("role=%q finishReason=%q", resp.Candidates[0].Content.Role, resp.Candidates[0].FinishReason)
+	}
+	if u := resp.UsageMetadata; u["totalTokenCount"] != u["promptTokenCount"]+u["candidatesTokenCount"] || u["candidatesTokenCount"] == 0 {
+		t.Errorf("usageMetadata = %v", u)
+	}
+}
+
+// geminiStreamChunks 解析 streamGenerateContent 的响应，sse 为 false 时响应是一个 JSON 数组
+func geminiStreamChunks(t *testing.T, body string, sse bool) []map[string]any {
+	t.Helper()
+	var chunks []map[string]any
+	if !sse {
+		if err := json.Unmarshal([]byte(body), &chunks); err != nil {
+			t.Fatalf("body is not a JSON array: %v\n%s", err, body)
+		}
+		return chunks
+	}
+	scanner := bufio.NewScanner(strings.NewReader(body))
+	for scanner.Scan() {
+		line, ok := strings.CutPrefix(strings.TrimSpace(scanner.Text()), "data: ")
+		if !ok {
+			continue
+		}
+		var chunk map[string]any
+		if err := json.Unmarshal([]byte(line), &chunk); err != nil {
+			t.Fatalf("bad chunk %q: %v", line, err)
+		}
+		chunks = append(chunks, chunk)
+	}
+	return chunks
+}
+
+func TestGeminiStreamGenerateContent(t *testing.T) {
+	withMockUpstream(t)
+
+	body := `{"tools":[{"functionDeclarations":[{"name":"Read"}]}],
+		"contents":[{"role":"user","parts":[{"text":"[mock:tool] read it"}]}]}`
+	for _, sse := range []bool{false, true} {
+		target := "/v1beta/models/claude-sonnet-4-20250514:streamGenerateContent"
+		if sse {
+			target += "?alt=sse"
+		}
+		rec := httptest.NewRecorder()
+		handleGemini(rec, httptest.NewRequest(http.MethodPost, target, strings.NewReader(body)))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("sse=%v: status = %d, body %s", sse, rec.Code, rec.Body)
+		}
+
+		chunks := geminiStreamChunks(t, rec.Body.String(), sse)
+		var calls []string
+		for _, chunk := range chunks {
+			candidate := chunk["candidates"].([]any)[0].(map[string]any)
+			for _, part := range candidate["content"].(map[string]any)["parts"].([]any) {
+				if call, ok := part.(map[string]any)["functionCall"].(map[string]any); ok {
+					calls = append(calls, call["name"].(string))
+				}
+			}
+		}
+		if !reflect.DeepEqual(calls, []string{"Read"}) {
+			t.Errorf("sse=%v: function calls = %v", sse, calls)
+		}
+		last := chunks[len(chunks)-1]
+		if last["usageMetadata"] == nil || last["candidates"].([]any)[0].(map[string]any)["finishReason"] != "STOP" {
+			t.Errorf("sse=%v: last chunk = %v", sse, last)
+		}
+	}
+}
+
+func TestGeminiErrors(t *testing.T) {
+	withMockUpstream(t)
+
+	tests := []struct {
+		path, body, status string
+		code               int
+	}{
+		{"/v1beta/models/gemini-2.0-flash:generateContent", `{"contents":[]}`, "NOT_FOUND", http.StatusNotFound},
+		{"/v1beta/models/claude-sonnet-4-20250514:embedContent", `{}`, "NOT_FOUND", http.StatusNotFound},
+		{"/v1beta/models/claude-sonnet-4-20250514:generateContent", `{"generationConfig":{"candidateCount":2},"contents":[]}`, "INVALID_ARGUMENT", http.StatusBadRequest},
+		{"/v1beta/models/claude-sonnet-4-20250514:generateContent", `{"contents":[{"parts":[{"text":"[mock:throttle] hi"}]}]}`, "RESOURCE_EXHAUSTED", http.StatusTooManyRequests},
+	}
+
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		handleGemini(rec, httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body)))
+		var resp struct {
+			Error struct {
+				Code   int    `json:"code"`
+				Status string `json:"status"`
+			} `json:"error"`
+		}
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		if rec.Code != tt.code || resp.Error.Code != tt.code || resp.Error.Status != tt.status {
+			t.Errorf("%s %s: status %d, body %s", tt.path, tt.body, rec.Code, rec.Body)
+		}
+	}
+}
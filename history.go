@@ -0,0 +1,73 @@
+package main
+
+import "fmt"
+
+const (
+	// leadingUserContent 在以 assistant 开头的对话前补的 user 消息
+	leadingUserContent = "."
+	// prefillUserContent 最后一条为 assistant 预填充时追加的 user 消息，让模型接着往下写
+	prefillUserContent = "Continue your previous response from exactly where it stopped, without repeating any of it."
+)
+
+// normalizeMessages 把 Anthropic API 允许的任意消息序列整理为 CodeWhisperer 要求的结构：
+// 以 user 开始、user 与 assistant 严格交替、以 user 结束。
+// 连续的同角色消息合并为一条，开头的 assistant 前补一条 user，
+// 末尾的 assistant（预填充）保留在历史中并追加一条要求继续的 user 消息。
+func normalizeMessages(messages []AnthropicRequestMessage) ([]AnthropicRequestMessage, error) {
+	if len(messages) == 0 {
+		return nil, &invalidRequestError{"messages: at least one message is required"}
+	}
+
+	normalized := make([]AnthropicRequestMessage, 0, len(messages)+2)
+	for i, msg := range messages {
+		if msg.Role != "user" && msg.Role != "assistant" {
+			return nil, &invalidRequestError{fmt.Sprintf("messages.%d.role: unexpected role %q, expected user or assistant", i, msg.Role)}
+		}
+
+		if len(normalized) == 0 && msg.Role == "assistant" {
+			normalized = append(normalized, AnthropicRequestMessage{Role: "user", Content: leadingUserContent})
+		}
+
+		if last := len(normalized) - 1; last >= 0 && normalized[last].Role == msg.Role {
+			normalized[last].Content = mergeContent(normalized[last].Content, msg.Content)
+			continue
+		}
+		normalized = append(normalized, msg)
+	}
+
+	if normalized[len(normalized)-1].Role == "assistant" {
+		normalized = append(normalized, AnthropicRequestMessage{Role: "user", Content: prefillUserContent})
+	}
+
+	return normalized, nil
+}
+
+// prependSystemPrompt 把 system 提示词放到第一条 user 消息的开头
+func prependSystemPrompt(systemPrompt, content string) string {
+	switch {
+	case systemPrompt == "":
+		return content
+	case content == "":
+		return systemPrompt
+	default:
+		return systemPrompt + "\n\n" + content
+	}
+}
+
+// mergeContent 把两条消息的内容拼成一个内容块数组
+func mergeContent(a, b any) any {
+	return append(contentAsBlocks(a), contentAsBlocks(b)...)
+}
+
+// contentAsBlocks 把 string 内容转为单个 text 块，块数组原样返回
+func contentAsBlocks(content any) []interface{} {
+	switch v := content.(type) {
+	case string:
+		return []interface{}{map[string]interface{}{"type": "text", "text": v}}
+	case []interface{}:
+		// 复制一份，避免 append 改写调用方的底层数组
+		return append([]interface{}(nil), v...)
+	default:
+		return []interface{}{map[string]interface{}{"type": "text", "text": getMessageContent(v)}}
+	}
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func text(s string) map[string]interface{} {
+	return map[string]interface{}{"type": "text", "text": s}
+}
+
+func TestNormalizeMessages(t *testing.T) {
+	toolUse := map[string]interface{}{"type": "tool_use", "id": "tu_1", "name": "Bash", "input": map[string]interface{}{}}
+	toolResult := map[string]interface{}{"type": "tool_result", "tool_use_id": "tu_1", "content": "ok"}
+
+	tests := []struct {
+		name     string
+		messages []AnthropicRequestMessage
+		want     []AnthropicRequestMessage
+	}{
+		{
+			name:     "single user",
+			messages: []AnthropicRequestMessage{{Role: "user", Content: "hi"}},
+			want:     []AnthropicRequestMessage{{Role: "user", Content: "hi"}},
+		},
+		{
+			name: "alternating",
+			messages: []AnthropicRequestMessage{
+				{Role: "user", Content: "hi"},
+				{Role: "assistant", Content: "hello"},
+				{Role: "user", Content: "bye"},
+			},
+			want: []AnthropicRequestMessage{
+				{Role: "user", Content: "hi"},
+				{Role: "assistant", Content: "hello"},
+				{Role: "user", Content: "bye"},
+			},
+		},
+		{
+			name: "consecutive users",
+			messages: []AnthropicRequestMessage{
+				{Role: "user", Content: "first"},
+				{Role: "user", Content: []interface{}{text("second"), toolResult}},
+			},
+			want: []AnthropicRequestMessage{
+				{Role: "user", Content: []interface{}{text("first"), text("second"), toolResult}},
+			},
+		},
+		{
+			name: "consecutive assistants",
+			messages: []AnthropicRequestMessage{
+				{Role: "user", Content: "hi"},
+				{Role: "assistant", Content: "thinking"},
+				{Role: "assistant", Content: []interface{}{toolUse}},
+				{Role: "user", Content: []interface{}{toolResult}},
+			},
+			want: []AnthropicRequestMessage{
+				{Role: "user", Content: "hi"},
+				{Role: "assistant", Content: []interface{}{text("thinking"), toolUse}},
+				{Role: "user", Content: []interface{}{toolResult}},
+			},
+		},
+		{
+			name: "leading assistant",
+			messages: []AnthropicRequestMessage{
+				{Role: "assistant", Content: "How can I help?"},
+				{Role: "user", Content: "fix the bug"},
+			},
+			want: []AnthropicRequestMessage{
+				{Role: "user", Content: leadingUserContent},
+				{Role: "assistant", Content: "How can I help?"},
+				{Role: "user", Content: "fix the bug"},
+			},
+		},
+		{
+			name: "assistant prefill",
+			messages: []AnthropicRequestMessage{
+				{Role: "user", Content: "give me JSON"},
+				{Role: "assistant", Content: "{"},
+			},
+			want: []AnthropicRequestMessage{
+				{Role: "user", Content: "give me JSON"},
+				{Role: "assistant", Content: "{"},
+				{Role: "user", Content: prefillUserContent},
+			},
+		},
+		{
+			name:     "only assistant",
+			messages: []AnthropicRequestMessage{{Role: "assistant", Content: "partial"}},
+			want: []AnthropicRequestMessage{
+				{Role: "user", Content: leadingUserContent},
+				{Role: "assistant", Content: "partial"},
+				{Role: "user", Content: prefillUserContent},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := normalizeMessages(tt.messages)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got  %#v\nwant %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeMessagesDoesNotModifyInput(t *testing.T) {
+	first := make([]interface{}, 1, 4)
+	first[0] = text("a")
+	messages := []AnthropicRequestMessage{
+		{Role: "user", Content: first},
+		{Role: "user", Content: "b"},
+	}
+
+	if _, err := normalizeMessages(messages); err != nil {
+		t.Fatal(err)
+	}
+	if spare := first[:2][1]; spare != nil {
+		t.Errorf("merge wrote into the caller's content slice: %#v", spare)
+	}
+}
+
+func TestNormalizeMessagesErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		messages []AnthropicRequestMessage
+	}{
+		{"empty", nil},
+		{"unknown role", []AnthropicRequestMessage{{Role: "system", Content: "be nice"}}},
+	}
+
+	for _, tt := range tests {
+		_, err := normalizeMessages(tt.messages)
+		var reqErr *invalidRequestError
+		if !errors.As(err, &reqErr) {
+			t.Errorf("%s: got %v, want *invalidRequestError", tt.name, err)
+		}
+	}
+}
+
+func TestBuildCodeWhispererRequestAlternates(t *testing.T) {
+	req := AnthropicRequest{
+		Model: "claude-sonnet-4-20250514",
+		Messages: []AnthropicRequestMessage{
+			{Role: "assistant", Content: "Hi"},
+			{Role: "user", Content: "one"},
+			{Role: "user", Content: "two"},
+			{Role: "assistant", Content: "reply"},
+		},
+	}
+
+	cwReq, err := buildCodeWhispererRequest(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	history := cwReq.ConversationState.History
+	if len(history) != 4 {
+		t.Fatalf("got %d history entries, want 4", len(history))
+	}
+	for i, entry := range history {
+		_, isUser := entry.(HistoryUserMessage)
+		if isUser != (i%2 == 0) {
+			t.Errorf("history[%d] is %T, breaks user/assistant alternation", i, entry)
+		}
+	}
+	if got := history[2].(HistoryUserMessage).UserInputMessage.Content; got != "one\ntwo" {
+		t.Errorf("merged user content = %q, want %q", got, "one\ntwo")
+	}
+	if got := cwReq.ConversationState.CurrentMessage.UserInputMessage.Content; got != prefillUserContent {
+		t.Errorf("current message = %q, want prefill continuation", got)
+	}
+}
+
+func TestAnthropicSystemUnmarshal(t *testing.T) {
+	tests := []struct {
+		body string
+		want AnthropicSystem
+	}{
+		{`{"system":"be brief"}`, AnthropicSystem{{Type: "text", Text: "be brief"}}},
+		{`{"system":""}`, nil},
+		{`{}`, nil},
+		{
+			`{"system":[{"type":"text","text":"a"},{"type":"text","text":"b","cache_control":{"type":"ephemeral"}}]}`,
+			AnthropicSystem{{Type: "text", Text: "a"}, {Type: "text", Text: "b", CacheControl: &CacheControl{Type: "ephemeral"}}},
+		},
+	}
+
+	for _, tt := range tests {
+		var req AnthropicRequest
+		if err := json.Unmarshal([]byte(tt.body), &req); err != nil {
+			t.Errorf("%s: %v", tt.body, err)
+			continue
+		}
+		if !reflect.DeepEqual(req.System, tt.want) {
+			t.Errorf("%s: got %#v, want %#v", tt.body, req.System, tt.want)
+		}
+	}
+
+	var req AnthropicRequest
+	if err := json.Unmarshal([]byte(`{"system":42}`), &req); err == nil {
+		t.Error("numeric system: expected an error")
+	}
+}
+
+func TestSystemPromptJoinsFirstUserTurn(t *testing.T) {
+	system := AnthropicSystem{{Type: "text", Text: "rule 1"}, {Type: "text", Text: "rule 2"}}
+
+	single, err := buildCodeWhispererRequest(AnthropicRequest{
+		System:   system,
+		Messages: []AnthropicRequestMessage{{Role: "user", Content: "hi"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(single.ConversationState.History) != 0 {
+		t.Errorf("system prompt added %d history turns", len(single.ConversationState.History))
+	}
+	if got, want := single.ConversationState.CurrentMessage.UserInputMessage.Content, "rule 1\n\nrule 2\n\nhi"; got != want {
+		t.Errorf("current content = %q, want %q", got, want)
+	}
+
+	multi, err := buildCodeWhispererRequest(AnthropicRequest{
+		System: system,
+		Messages: []AnthropicRequestMessage{
+			{Role: "user", Content: "hi"},
+			{Role: "assistant", Content: "hello"},
+			{Role: "user", Content: "bye"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(multi.ConversationState.History) != 2 {
+		t.Fatalf("got %d history turns, want 2", len(multi.ConversationState.History))
+	}
+	if got, want := multi.ConversationState.History[0].(HistoryUserMessage).UserInputMessage.Content, "rule 1\n\nrule 2\n\nhi"; got != want {
+		t.Errorf("first user turn = %q, want %q", got, want)
+	}
+	if got := multi.ConversationState.CurrentMessage.UserInputMessage.Content; got != "bye" {
+		t.Errorf("current content = %q, want %q", got, "bye")
+	}
+}
@@ -21,6 +21,7 @@ func init() {
 		IdleConnTimeout:     90 * time.Second, // 空闲连接超时时间
 		DisableCompression:  false,            // 启用压缩
 		ForceAttemptHTTP2:   true,             // 强制尝试HTTP/2
+		Proxy:               upstreamClient.proxy,
 	}
 
 	httpClientManager.client = &http.Client{
@@ -43,6 +44,7 @@ func (hcm *HTTPClientManager) GetStreamingClient() *http.Client {
 		IdleConnTimeout:     90 * time.Second,
 		DisableCompression:  false,
 		ForceAttemptHTTP2:   true,
+		Proxy:               upstreamClient.proxy,
 	}
 
 	return &http.Client{
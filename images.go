@@ -0,0 +1,167 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// maxImageBytes 单张图片解码后的大小上限，与 Anthropic API 的限制一致
+const maxImageBytes = 5 << 20
+
+// imageFormats 支持的图片 media_type 到 CodeWhisperer format 的映射
+var imageFormats = map[string]string{
+	"image/jpeg": "jpeg",
+	"image/png":  "png",
+	"image/gif":  "gif",
+	"image/webp": "webp",
+}
+
+// ImageSource 表示 image 内容块的来源，base64 或 url
+type ImageSource struct {
+	Type      string `json:"type"`
+	MediaType string `json:"media_type,omitempty"`
+	Data      string `json:"data,omitempty"`
+	URL       string `json:"url,omitempty"`
+}
+
+// CodeWhispererImage 表示 userInputMessage 中的图片
+type CodeWhispererImage struct {
+	Format string `json:"format"`
+	Source struct {
+		Bytes string `json:"bytes"` // base64 编码的图片内容
+	} `json:"source"`
+}
+
+// imageFingerprint 返回消息中所有 image 内容块的摘要，没有图片时返回空串
+func imageFingerprint(content any) string {
+	blocks, ok := content.([]interface{})
+	if !ok {
+		return ""
+	}
+
+	h := md5.New()
+	found := false
+	for _, block := range blocks {
+		if m, ok := block.(map[string]interface{}); ok && m["type"] == "image" {
+			data, _ := json.Marshal(m["source"])
+			h.Write(data)
+			found = true
+		}
+	}
+	if !found {
+		return ""
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// getMessageImages 从消息中提取 image 内容块并转换为 CodeWhisperer 图片
+func getMessageImages(content any) ([]CodeWhispererImage, error) {
+	blocks, ok := content.([]interface{})
+	if !ok {
+		return nil, nil
+	}
+
+	var images []CodeWhispererImage
+	for i, block := range blocks {
+		m, ok := block.(map[string]interface{})
+		if !ok || m["type"] != "image" {
+			continue
+		}
+
+		var cb ContentBlock
+		if data, err := json.Marshal(m); err == nil {
+			json.Unmarshal(data, &cb)
+		}
+		if cb.Source == nil {
+			return nil, &invalidRequestError{fmt.Sprintf("content.%d: image block is missing source", i)}
+		}
+
+		image, err := convertImageSource(cb.Source)
+		if err != nil {
+			return nil, &invalidRequestError{fmt.Sprintf("content.%d: %v", i, err)}
+		}
+		images = append(images, image)
+	}
+	return images, nil
+}
+
+// convertImageSource 校验图片来源并转换为 CodeWhisperer 格式
+func convertImageSource(src *ImageSource) (CodeWhispererImage, error) {
+	var image CodeWhispererImage
+
+	mediaType := src.MediaType
+	var data []byte
+	switch src.Type {
+	case "base64":
+		decoded, err := base64.StdEncoding.DecodeString(src.Data)
+		if err != nil {
+			return image, fmt.Errorf("image data is not valid base64: %v", err)
+		}
+		data = decoded
+	case "url":
+		fetched, fetchedType, err := fetchImage(src.URL)
+		if err != nil {
+			return image, err
+		}
+		data = fetched
+		if mediaType == "" {
+			mediaType = fetchedType
+		}
+		// 有些服务器只返回 application/octet-stream，此时按内容识别
+		if _, ok := imageFormats[mediaType]; !ok {
+			mediaType = http.DetectContentType(data)
+		}
+	default:
+		return image, fmt.Errorf("unsupported image source type %q", src.Type)
+	}
+
+	format, ok := imageFormats[mediaType]
+	if !ok {
+		return image, fmt.Errorf("unsupported image media type %q, expected one of image/jpeg, image/png, image/gif, image/webp", mediaType)
+	}
+	if len(data) == 0 {
+		return image, fmt.Errorf("image data is empty")
+	}
+	if len(data) > maxImageBytes {
+		return image, fmt.Errorf("image exceeds %d MB maximum: %d bytes", maxImageBytes>>20, len(data))
+	}
+	if detected := http.DetectContentType(data); detected != mediaType {
+		return image, fmt.Errorf("image data does not match media type %s (looks like %s)", mediaType, detected)
+	}
+
+	image.Format = format
+	image.Source.Bytes = base64.StdEncoding.EncodeToString(data)
+	return image, nil
+}
+
+// fetchImage 下载 url 来源的图片，返回内容和响应的 media type
+func fetchImage(url string) ([]byte, string, error) {
+	if !strings.HasPrefix(url, "https://") && !strings.HasPrefix(url, "http://") {
+		return nil, "", fmt.Errorf("image url must be http or https")
+	}
+
+	resp, err := httpClientManager.GetClient().Get(url)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to fetch image: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("failed to fetch image: status %d", resp.StatusCode)
+	}
+
+	// 多读一个字节以便识别超限
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxImageBytes+1))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to fetch image: %v", err)
+	}
+
+	mediaType, _, _ := strings.Cut(resp.Header.Get("Content-Type"), ";")
+	return data, strings.TrimSpace(mediaType), nil
+}
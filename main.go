@@ -1,9 +1,9 @@
 package main
 
 import (
-	"bytes"
 	"encoding/json"
 	jsonStr "encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -15,7 +15,9 @@ import (
 	"strings"
 	"time"
 
+	"github.com/bestk/kiro2cc/mockupstream"
 	"github.com/bestk/kiro2cc/parser"
+	"github.com/bestk/kiro2cc/tokenizer"
 )
 
 // TokenData 表示token文件的结构
@@ -42,6 +44,8 @@ type AnthropicTool struct {
 	Name        string         `json:"name"`
 	Description string         `json:"description"`
 	InputSchema map[string]any `json:"input_schema"`
+
+	CacheControl *CacheControl `json:"cache_control,omitempty"`
 }
 
 // InputSchema 表示工具输入模式的结构
@@ -64,30 +68,38 @@ type CodeWhispererTool struct {
 // HistoryUserMessage 表示历史记录中的用户消息
 type HistoryUserMessage struct {
 	UserInputMessage struct {
-		Content string `json:"content"`
-		ModelId string `json:"modelId"`
-		Origin  string `json:"origin"`
+		Content string               `json:"content"`
+		ModelId string               `json:"modelId"`
+		Origin  string               `json:"origin"`
+		Images  []CodeWhispererImage `json:"images,omitempty"`
+
+		UserInputMessageContext *UserInputMessageContext `json:"userInputMessageContext,omitempty"`
 	} `json:"userInputMessage"`
 }
 
 // HistoryAssistantMessage 表示历史记录中的助手消息
 type HistoryAssistantMessage struct {
 	AssistantResponseMessage struct {
-		Content  string `json:"content"`
-		ToolUses []any  `json:"toolUses"`
+		Content  string                 `json:"content"`
+		ToolUses []CodeWhispererToolUse `json:"toolUses"`
 	} `json:"assistantResponseMessage"`
 }
 
 // AnthropicRequest 表示 Anthropic API 的请求结构
 type AnthropicRequest struct {
-	Model       string                    `json:"model"`
-	MaxTokens   int                       `json:"max_tokens"`
-	Messages    []AnthropicRequestMessage `json:"messages"`
-	System      []AnthropicSystemMessage  `json:"system,omitempty"`
-	Tools       []AnthropicTool           `json:"tools,omitempty"`
-	Stream      bool                      `json:"stream"`
-	Temperature *float64                  `json:"temperature,omitempty"`
-	Metadata    map[string]any            `json:"metadata,omitempty"`
+	Model         string                    `json:"model"`
+	MaxTokens     int                       `json:"max_tokens"`
+	Messages      []AnthropicRequestMessage `json:"messages"`
+	System        AnthropicSystem           `json:"system,omitempty"`
+	Tools         []AnthropicTool           `json:"tools,omitempty"`
+	Stream        bool                      `json:"stream"`
+	Temperature   *float64                  `json:"temperature,omitempty"`
+	TopP          *float64                  `json:"top_p,omitempty"`
+	TopK          *int                      `json:"top_k,omitempty"`
+	StopSequences []string                  `json:"stop_sequences,omitempty"`
+	ToolChoice    *AnthropicToolChoice      `json:"tool_choice,omitempty"`
+	Thinking      *AnthropicThinking        `json:"thinking,omitempty"`
+	Metadata      map[string]any            `json:"metadata,omitempty"`
 }
 
 // AnthropicStreamResponse 表示 Anthropic 流式响应的结构
@@ -116,19 +128,66 @@ type AnthropicRequestMessage struct {
 	Content any    `json:"content"` // 可以是 string 或 []ContentBlock
 }
 
+// AnthropicSystemMessage 表示 system 中的一个文本块
 type AnthropicSystemMessage struct {
+	Type         string        `json:"type"`
+	Text         string        `json:"text"`
+	CacheControl *CacheControl `json:"cache_control,omitempty"`
+}
+
+// CacheControl 表示内容块上的 cache_control 标记。
+// CodeWhisperer 没有对应的提示缓存，标记原样保留以便请求可以无损地重新序列化
+type CacheControl struct {
 	Type string `json:"type"`
-	Text string `json:"text"` // 可以是 string 或 []ContentBlock
+	TTL  string `json:"ttl,omitempty"`
+}
+
+// AnthropicSystem 表示 system 字段，Anthropic API 允许字符串或文本块数组两种形式
+type AnthropicSystem []AnthropicSystemMessage
+
+// UnmarshalJSON 同时接受字符串和文本块数组
+func (s *AnthropicSystem) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := jsonStr.Unmarshal(data, &text); err == nil {
+		*s = nil
+		if text != "" {
+			*s = AnthropicSystem{{Type: "text", Text: text}}
+		}
+		return nil
+	}
+
+	var blocks []AnthropicSystemMessage
+	if err := jsonStr.Unmarshal(data, &blocks); err != nil {
+		return fmt.Errorf("system: expected a string or an array of text blocks: %v", err)
+	}
+	*s = blocks
+	return nil
+}
+
+// Text 返回所有 system 文本块拼接后的内容
+func (s AnthropicSystem) Text() string {
+	var texts []string
+	for _, block := range s {
+		if block.Text != "" {
+			texts = append(texts, block.Text)
+		}
+	}
+	return strings.Join(texts, "\n\n")
 }
 
 // ContentBlock 表示消息内容块的结构
 type ContentBlock struct {
-	Type      string  `json:"type"`
-	Text      *string `json:"text,omitempty"`
-	ToolUseId *string `json:"tool_use_id,omitempty"`
-	Content   *string `json:"content,omitempty"`
-	Name      *string `json:"name,omitempty"`
-	Input     *any    `json:"input,omitempty"`
+	Type      string       `json:"type"`
+	Text      *string      `json:"text,omitempty"`
+	Id        *string      `json:"id,omitempty"`
+	ToolUseId *string      `json:"tool_use_id,omitempty"`
+	Content   any          `json:"content,omitempty"` // tool_result 的内容，可以是 string 或 []ContentBlock
+	IsError   *bool        `json:"is_error,omitempty"`
+	Name      *string      `json:"name,omitempty"`
+	Input     *any         `json:"input,omitempty"`
+	Source    *ImageSource `json:"source,omitempty"`
+
+	CacheControl *CacheControl `json:"cache_control,omitempty"`
 }
 
 // getMessageContent 从消息中提取文本内容
@@ -141,6 +200,7 @@ func getMessageContent(content any) string {
 		return v
 	case []interface{}:
 		var texts []string
+		hasImage := false
 		for _, block := range v {
 
 			if m, ok := block.(map[string]interface{}); ok {
@@ -149,9 +209,11 @@ func getMessageContent(content any) string {
 					if err := jsonStr.Unmarshal(data, &cb); err == nil {
 						switch cb.Type {
 						case "tool_result":
-							texts = append(texts, *cb.Content)
+							texts = append(texts, toolResultText(cb.Content))
 						case "text":
 							texts = append(texts, *cb.Text)
+						case "image":
+							hasImage = true
 						}
 					}
 
@@ -160,6 +222,11 @@ func getMessageContent(content any) string {
 
 		}
 		if len(texts) == 0 {
+			// 只有图片的消息，图片通过 images 字段发送
+			if hasImage {
+				return ""
+			}
+
 			s, err := jsonStr.Marshal(content)
 			if err != nil {
 				return "answer for user qeustion"
@@ -187,19 +254,11 @@ type CodeWhispererRequest struct {
 		ConversationId  string `json:"conversationId"`
 		CurrentMessage  struct {
 			UserInputMessage struct {
-				Content                 string `json:"content"`
-				ModelId                 string `json:"modelId"`
-				Origin                  string `json:"origin"`
-				UserInputMessageContext struct {
-					ToolResults []struct {
-						Content []struct {
-							Text string `json:"text"`
-						} `json:"content"`
-						Status    string `json:"status"`
-						ToolUseId string `json:"toolUseId"`
-					} `json:"toolResults,omitempty"`
-					Tools []CodeWhispererTool `json:"tools,omitempty"`
-				} `json:"userInputMessageContext"`
+				Content                 string                  `json:"content"`
+				ModelId                 string                  `json:"modelId"`
+				Origin                  string                  `json:"origin"`
+				Images                  []CodeWhispererImage    `json:"images,omitempty"`
+				UserInputMessageContext UserInputMessageContext `json:"userInputMessageContext"`
 			} `json:"userInputMessage"`
 		} `json:"currentMessage"`
 		History []any `json:"history"`
@@ -229,20 +288,49 @@ func generateUUID() string {
 	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
 }
 
-// buildCodeWhispererRequest 构建 CodeWhisperer 请求
-func buildCodeWhispererRequest(anthropicReq AnthropicRequest) CodeWhispererRequest {
+// buildCodeWhispererRequest 构建 CodeWhisperer 请求，请求内容不合法时返回 *invalidRequestError
+func buildCodeWhispererRequest(anthropicReq AnthropicRequest) (CodeWhispererRequest, error) {
 	cwReq := CodeWhispererRequest{
-		ProfileArn: "arn:aws:codewhisperer:us-east-1:699475941385:profile/EHGA3GRVQMUK",
+		ProfileArn: upstreamClient.ProfileArn(),
 	}
 	cwReq.ConversationState.ChatTriggerType = "MANUAL"
 	cwReq.ConversationState.ConversationId = generateUUID()
-	cwReq.ConversationState.CurrentMessage.UserInputMessage.Content = getMessageContent(anthropicReq.Messages[len(anthropicReq.Messages)-1].Content)
+
+	if err := validateThinking(anthropicReq); err != nil {
+		return cwReq, err
+	}
+	if err := validateRequestParams(anthropicReq); err != nil {
+		return cwReq, err
+	}
+
+	// 整理为严格交替、以 user 结尾的消息序列
+	messages, err := normalizeMessages(anthropicReq.Messages)
+	if err != nil {
+		return cwReq, err
+	}
+	lastMessage := messages[len(messages)-1]
+	images, err := getMessageImages(lastMessage.Content)
+	if err != nil {
+		return cwReq, fmt.Errorf("messages.%d.%w", len(messages)-1, err)
+	}
+	// system 提示词并入第一条 user 消息，不再额外占用历史轮次
+	systemPrompt := anthropicReq.System.Text()
+	if thinkingEnabled(anthropicReq) {
+		systemPrompt = prependSystemPrompt(thinkingPrompt(anthropicReq), systemPrompt)
+	}
+	currentContent := appendInstruction(getUserInputContent(lastMessage.Content), toolChoicePrompt(anthropicReq))
+	if len(messages) == 1 {
+		currentContent = prependSystemPrompt(systemPrompt, currentContent)
+	}
+	cwReq.ConversationState.CurrentMessage.UserInputMessage.Content = currentContent
+	cwReq.ConversationState.CurrentMessage.UserInputMessage.Images = images
+	cwReq.ConversationState.CurrentMessage.UserInputMessage.UserInputMessageContext.ToolResults = getToolResults(lastMessage.Content)
 	cwReq.ConversationState.CurrentMessage.UserInputMessage.ModelId = ModelMap[anthropicReq.Model]
 	cwReq.ConversationState.CurrentMessage.UserInputMessage.Origin = "AI_EDITOR"
-	// 处理 tools 信息
-	if len(anthropicReq.Tools) > 0 {
+	// 处理 tools 信息，按 tool_choice 过滤
+	if reqTools := requestTools(anthropicReq); len(reqTools) > 0 {
 		var tools []CodeWhispererTool
-		for _, tool := range anthropicReq.Tools {
+		for _, tool := range reqTools {
 			cwTool := CodeWhispererTool{}
 			cwTool.ToolSpecification.Name = tool.Name
 			cwTool.ToolSpecification.Description = tool.Description
@@ -254,52 +342,37 @@ func buildCodeWhispererRequest(anthropicReq AnthropicRequest) CodeWhispererReque
 		cwReq.ConversationState.CurrentMessage.UserInputMessage.UserInputMessageContext.Tools = tools
 	}
 
-	// 构建历史消息
-	// 先处理 system 消息或者常规历史消息
-	if len(anthropicReq.System) > 0 || len(anthropicReq.Messages) > 1 {
+	// 构建历史消息，normalizeMessages 保证了 user/assistant 成对出现
+	if len(messages) > 1 {
 		var history []any
 
-		// 首先添加每个 system 消息作为独立的历史记录项
-
-		assistantDefaultMsg := HistoryAssistantMessage{}
-		assistantDefaultMsg.AssistantResponseMessage.Content = getMessageContent("I will follow these instructions")
-		assistantDefaultMsg.AssistantResponseMessage.ToolUses = make([]any, 0)
-
-		if len(anthropicReq.System) > 0 {
-			for _, sysMsg := range anthropicReq.System {
-				userMsg := HistoryUserMessage{}
-				userMsg.UserInputMessage.Content = sysMsg.Text
-				userMsg.UserInputMessage.ModelId = ModelMap[anthropicReq.Model]
-				userMsg.UserInputMessage.Origin = "AI_EDITOR"
-				history = append(history, userMsg)
-				history = append(history, assistantDefaultMsg)
+		for i := 0; i+1 < len(messages)-1; i += 2 {
+			userMsg := HistoryUserMessage{}
+			userMsg.UserInputMessage.Content = getUserInputContent(messages[i].Content)
+			if i == 0 {
+				userMsg.UserInputMessage.Content = prependSystemPrompt(systemPrompt, userMsg.UserInputMessage.Content)
 			}
-		}
-
-		// 然后处理常规消息历史
-		for i := 0; i < len(anthropicReq.Messages)-1; i++ {
-			if anthropicReq.Messages[i].Role == "user" {
-				userMsg := HistoryUserMessage{}
-				userMsg.UserInputMessage.Content = getMessageContent(anthropicReq.Messages[i].Content)
-				userMsg.UserInputMessage.ModelId = ModelMap[anthropicReq.Model]
-				userMsg.UserInputMessage.Origin = "AI_EDITOR"
-				history = append(history, userMsg)
-
-				// 检查下一条消息是否是助手回复
-				if i+1 < len(anthropicReq.Messages)-1 && anthropicReq.Messages[i+1].Role == "assistant" {
-					assistantMsg := HistoryAssistantMessage{}
-					assistantMsg.AssistantResponseMessage.Content = getMessageContent(anthropicReq.Messages[i+1].Content)
-					assistantMsg.AssistantResponseMessage.ToolUses = make([]any, 0)
-					history = append(history, assistantMsg)
-					i++ // 跳过已处理的助手消息
-				}
+			userMsg.UserInputMessage.ModelId = ModelMap[anthropicReq.Model]
+			userMsg.UserInputMessage.Origin = "AI_EDITOR"
+			userMsg.UserInputMessage.Images, err = getMessageImages(messages[i].Content)
+			if err != nil {
+				return cwReq, fmt.Errorf("messages.%d.%w", i, err)
+			}
+			if toolResults := getToolResults(messages[i].Content); len(toolResults) > 0 {
+				userMsg.UserInputMessage.UserInputMessageContext = &UserInputMessageContext{ToolResults: toolResults}
 			}
+			history = append(history, userMsg)
+
+			assistantMsg := HistoryAssistantMessage{}
+			assistantMsg.AssistantResponseMessage.Content = getAssistantContent(messages[i+1].Content)
+			assistantMsg.AssistantResponseMessage.ToolUses = getToolUses(messages[i+1].Content)
+			history = append(history, assistantMsg)
 		}
 
 		cwReq.ConversationState.History = history
 	}
 
-	return cwReq
+	return cwReq, nil
 }
 
 func main() {
@@ -310,6 +383,7 @@ func main() {
 		fmt.Println("  kiro2cc export  - 导出环境变量")
 		fmt.Println("  kiro2cc claude  - 跳过 claude 地区限制")
 		fmt.Println("  kiro2cc server [port] - 启动Anthropic API代理服务器")
+		fmt.Println("  kiro2cc mock-upstream [port] [scenario] - 启动本地模拟CodeWhisperer上游")
 		fmt.Println("  author https://github.com/bestK/kiro2cc")
 		os.Exit(1)
 	}
@@ -332,6 +406,16 @@ func main() {
 			port = os.Args[2]
 		}
 		startServer(port)
+	case "mock-upstream":
+		port := "8081"
+		if len(os.Args) > 2 {
+			port = os.Args[2]
+		}
+		scenario := ""
+		if len(os.Args) > 3 {
+			scenario = os.Args[3]
+		}
+		startMockUpstream(port, scenario)
 	default:
 		fmt.Printf("未知命令: %s\n", command)
 		os.Exit(1)
@@ -390,44 +474,14 @@ func refreshToken() {
 		os.Exit(1)
 	}
 
-	// 准备刷新请求
-	refreshReq := RefreshRequest{
-		RefreshToken: currentToken.RefreshToken,
-	}
-
-	reqBody, err := jsonStr.Marshal(refreshReq)
-	if err != nil {
-		fmt.Printf("序列化请求失败: %v\n", err)
-		os.Exit(1)
-	}
-
 	// 发送刷新请求
-	resp, err := http.Post(
-		"https://prod.us-east-1.auth.desktop.kiro.dev/refreshToken",
-		"application/json",
-		bytes.NewBuffer(reqBody),
-	)
+	newToken, err := upstreamClient.RefreshToken(currentToken.RefreshToken)
 	if err != nil {
-		fmt.Printf("刷新token请求失败: %v\n", err)
-		os.Exit(1)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		fmt.Printf("刷新token失败，状态码: %d, 响应: %s\n", resp.StatusCode, string(body))
-		os.Exit(1)
-	}
-
-	// 解析响应
-	var refreshResp RefreshResponse
-	if err := jsonStr.NewDecoder(resp.Body).Decode(&refreshResp); err != nil {
-		fmt.Printf("解析刷新响应失败: %v\n", err)
+		fmt.Printf("%v\n", err)
 		os.Exit(1)
 	}
 
 	// 更新token文件
-	newToken := TokenData(refreshResp)
 
 	newData, err := jsonStr.MarshalIndent(newToken, "", "  ")
 	if err != nil {
@@ -597,6 +651,35 @@ func (rw *responseWriter) WriteHeader(code int) {
 	rw.ResponseWriter.WriteHeader(code)
 }
 
+// Flush 透传给底层ResponseWriter，否则流式响应无法工作
+func (rw *responseWriter) Flush() {
+	if f, ok := rw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// startMockUpstream 启动本地模拟CodeWhisperer上游
+func startMockUpstream(port, scenario string) {
+	srv := mockupstream.New()
+	if scenario != "" {
+		if _, ok := srv.Scenarios[scenario]; !ok {
+			fmt.Printf("未知场景: %s，可选: %s\n", scenario, strings.Join(srv.ScenarioNames(), ", "))
+			os.Exit(1)
+		}
+		srv.Default = scenario
+	}
+
+	fmt.Printf("启动模拟CodeWhisperer上游，监听端口: %s，默认场景: %s\n", port, srv.Default)
+	fmt.Printf("可用场景: %s（在消息中加入 [mock:<场景>] 可按请求切换）\n", strings.Join(srv.ScenarioNames(), ", "))
+	fmt.Printf("在 kiro2cc-config.json 中配置以下内容让代理使用它:\n")
+	fmt.Printf("  \"mock_upstream\": {\"enabled\": true, \"url\": \"http://localhost:%s/generateAssistantResponse\"}\n", port)
+
+	if err := http.ListenAndServe(":"+port, srv); err != nil {
+		fmt.Printf("启动模拟上游失败: %v\n", err)
+		os.Exit(1)
+	}
+}
+
 // startServer 启动HTTP代理服务器
 func startServer(port string) {
 	// 创建路由器
@@ -634,7 +717,7 @@ func startServer(port string) {
 		var anthropicReq AnthropicRequest
 		if err := jsonStr.Unmarshal(body, &anthropicReq); err != nil {
 			fmt.Printf("错误: 解析请求体失败: %v\n", err)
-			http.Error(w, fmt.Sprintf("解析请求体失败: %v", err), http.StatusBadRequest)
+			writeErrorResponse(w, parser.InvalidRequestError, fmt.Sprintf("解析请求体失败: %v", err))
 			return
 		}
 
@@ -695,7 +778,15 @@ func startServer(port string) {
 		select {
 		case dedupeResp := <-dedupeResponseCh:
 			if dedupeResp.Error != nil {
-				http.Error(w, dedupeResp.Error.Error(), http.StatusInternalServerError)
+				writeUpstreamError(w, dedupeResp.Error)
+				metrics.RecordError()
+				return
+			}
+
+			cwRespBody, _ := dedupeResp.Response.([]byte)
+			anthropicResp, err := buildAnthropicResponse(anthropicReq, cwRespBody)
+			if err != nil {
+				writeUpstreamError(w, err)
 				metrics.RecordError()
 				return
 			}
@@ -717,7 +808,7 @@ func startServer(port string) {
 				predictiveCache.Set(compressedReq, dedupeResp.Response)
 			}
 
-			w.Write(dedupeResp.Response.([]byte))
+			json.NewEncoder(w).Encode(anthropicResp)
 			metrics.RecordRequest(time.Since(startTime), dedupeResp.FromCache, dedupeResp.Merged)
 
 		case <-time.After(45 * time.Second): // 增加超时时间以适应去重处理
@@ -726,6 +817,33 @@ func startServer(port string) {
 		}
 	}))
 
+	// 旧版 Text Completions 端点，prompt 解析为消息后走相同的上游流程
+	mux.HandleFunc("/v1/complete", logMiddleware(handleComplete))
+
+	// token 计数端点，本地估算，不需要上游 token
+	mux.HandleFunc("/v1/messages/count_tokens", logMiddleware(handleCountTokens))
+
+	// OpenAI Chat Completions 兼容端点
+	mux.HandleFunc("/v1/chat/completions", logMiddleware(handleOpenAIChatCompletions))
+
+	// OpenAI Responses API 兼容端点，previous_response_id 依赖本地对话存储
+	mux.HandleFunc("/v1/responses", logMiddleware(handleResponses))
+	mux.HandleFunc("/v1/responses/", logMiddleware(handleResponses))
+
+	// Gemini generateContent 兼容端点，路径为 /v1beta/models/{model}:generateContent 或 :streamGenerateContent
+	mux.HandleFunc("/v1beta/models/", logMiddleware(handleGemini))
+
+	// Ollama 兼容端点，供只支持 Ollama 的本地工具使用
+	if config.Ollama.Enabled {
+		mux.HandleFunc("/api/chat", logMiddleware(handleOllamaChat))
+		mux.HandleFunc("/api/generate", logMiddleware(handleOllamaGenerate))
+		mux.HandleFunc("/api/tags", logMiddleware(handleOllamaTags))
+	}
+
+	// 模型列表端点，由 ModelMap 生成
+	mux.HandleFunc("/v1/models", logMiddleware(handleModels))
+	mux.HandleFunc("/v1/models/", logMiddleware(handleModels))
+
 	// 添加健康检查端点
 	mux.HandleFunc("/health", logMiddleware(func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
@@ -851,8 +969,22 @@ func startServer(port string) {
 
 	// 启动服务器
 	fmt.Printf("启动Anthropic API代理服务器，监听端口: %s\n", port)
+	if config.MockUpstream.Enabled {
+		fmt.Printf("使用模拟上游: %s\n", config.MockUpstream.URL)
+	}
 	fmt.Printf("可用端点:\n")
 	fmt.Printf("  POST /v1/messages          - Anthropic API代理\n")
+	fmt.Printf("  POST /v1/messages/count_tokens - 估算输入token数\n")
+	fmt.Printf("  POST /v1/complete          - 旧版Text Completions兼容接口\n")
+	fmt.Printf("  POST /v1/chat/completions  - OpenAI Chat Completions兼容接口\n")
+	fmt.Printf("  POST /v1/responses         - OpenAI Responses API兼容接口\n")
+	fmt.Printf("  GET  /v1/models            - 可用模型列表\n")
+	fmt.Printf("  POST /v1beta/models/{model}:generateContent - Gemini兼容接口，流式为 :streamGenerateContent\n")
+	if config.Ollama.Enabled {
+		fmt.Printf("  POST /api/chat             - Ollama Chat兼容接口\n")
+		fmt.Printf("  POST /api/generate         - Ollama Generate兼容接口\n")
+		fmt.Printf("  GET  /api/tags             - Ollama模型列表\n")
+	}
 	fmt.Printf("  GET  /health               - 健康检查\n")
 	fmt.Printf("  GET  /stats                - 基础统计信息\n")
 	fmt.Printf("  GET  /stats/detailed       - 详细统计信息\n")
@@ -980,40 +1112,15 @@ func handleStreamRequest(w http.ResponseWriter, anthropicReq AnthropicRequest, a
 
 	messageId := fmt.Sprintf("msg_%s", time.Now().Format("20060102150405"))
 
-	// 构建 CodeWhisperer 请求
-	cwReq := buildCodeWhispererRequest(anthropicReq)
-
-	// 序列化请求体
-	cwReqBody, err := jsonStr.Marshal(cwReq)
+	// 构建并发送 CodeWhisperer 流式请求
+	cwReq, err := buildCodeWhispererRequest(anthropicReq)
 	if err != nil {
-		sendErrorEvent(w, flusher, "序列化请求失败", err)
+		writeUpstreamError(w, err)
 		return
 	}
-
-	// fmt.Printf("CodeWhisperer 流式请求体:\n%s\n", string(cwReqBody))
-
-	// 创建流式请求
-	proxyReq, err := http.NewRequest(
-		http.MethodPost,
-		"https://codewhisperer.us-east-1.amazonaws.com/generateAssistantResponse",
-		bytes.NewBuffer(cwReqBody),
-	)
+	resp, err := upstreamClient.GenerateAssistantResponse(accessToken, cwReq, true)
 	if err != nil {
-		sendErrorEvent(w, flusher, "创建代理请求失败", err)
-		return
-	}
-
-	// 设置请求头
-	proxyReq.Header.Set("Authorization", "Bearer "+accessToken)
-	proxyReq.Header.Set("Content-Type", "application/json")
-	proxyReq.Header.Set("Accept", "text/event-stream")
-
-	// 发送请求 (使用优化的HTTP客户端)
-	client := httpClientManager.GetStreamingClient()
-
-	resp, err := client.Do(proxyReq)
-	if err != nil {
-		sendErrorEvent(w, flusher, "CodeWhisperer reqeust error", fmt.Errorf("reqeust error: %s", err.Error()))
+		writeErrorResponse(w, parser.APIError, fmt.Sprintf("CodeWhisperer reqeust error: %v", err))
 		return
 	}
 	defer resp.Body.Close()
@@ -1021,135 +1128,88 @@ func handleStreamRequest(w http.ResponseWriter, anthropicReq AnthropicRequest, a
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
 		fmt.Printf("CodeWhisperer 响应错误，状态码: %d, 响应: %s\n", resp.StatusCode, string(body))
-		sendErrorEvent(w, flusher, "error", fmt.Errorf("状态码: %d", resp.StatusCode))
 
 		if resp.StatusCode == 403 {
 			// 异步刷新token，不阻塞当前请求
 			go tokenManager.refreshTokenAsync()
-			sendErrorEvent(w, flusher, "error", fmt.Errorf("CodeWhisperer Token 已过期，已异步刷新，请重试"))
+			writeErrorResponse(w, parser.AuthenticationError, "CodeWhisperer Token 已过期，已异步刷新，请重试")
 		} else {
-			sendErrorEvent(w, flusher, "error", fmt.Errorf("CodeWhisperer Error: %s ", string(body)))
+			writeUpstreamError(w, parser.NewHTTPError(resp.StatusCode, body))
 		}
 		return
 	}
 
-	// 先读取整个响应体
-	respBody, err := io.ReadAll(resp.Body)
+	// 发送开始事件
+	inputTokens := countInputTokens(anthropicReq)
+	messageStart := map[string]any{
+		"type": "message_start",
+		"message": map[string]any{
+			"id":            messageId,
+			"type":          "message",
+			"role":          "assistant",
+			"content":       []any{},
+			"model":         anthropicReq.Model,
+			"stop_reason":   nil,
+			"stop_sequence": nil,
+			"usage": map[string]any{
+				"input_tokens":                inputTokens,
+				"output_tokens":               1,
+				"cache_creation_input_tokens": 0,
+				"cache_read_input_tokens":     0,
+			},
+		},
+	}
+	sendSSEEvent(w, flusher, "message_start", messageStart)
+	sendSSEEvent(w, flusher, "ping", map[string]string{
+		"type": "ping",
+	})
+
+	// 边读取边解析，每解析出一个事件立即转发给客户端
+	translator := newTranslator(anthropicReq)
+	err = translator.Stream(resp.Body, func(e parser.SSEEvent) {
+		sendSSEEvent(w, flusher, e.Event, e.Data)
+	})
 	if err != nil {
-		sendErrorEvent(w, flusher, "error", fmt.Errorf("CodeWhisperer Error 读取响应失败"))
+		// 响应头已发送，只能通过 error 事件告知客户端
+		fmt.Printf("错误: 读取 CodeWhisperer 响应流失败: %v\n", err)
+		sendErrorEvent(w, flusher, upstreamErrorType(err), err)
 		return
 	}
 
-	// os.WriteFile(messageId+"response.raw", respBody, 0644)
-
-	// 使用新的CodeWhisperer解析器
-	events := parser.ParseEvents(respBody)
-
-	if len(events) > 0 {
-
-		// 发送开始事件
-		messageStart := map[string]any{
-			"type": "message_start",
-			"message": map[string]any{
-				"id":            messageId,
-				"type":          "message",
-				"role":          "assistant",
-				"content":       []any{},
-				"model":         anthropicReq.Model,
-				"stop_reason":   nil,
-				"stop_sequence": nil,
-				"usage": map[string]any{
-					"input_tokens":  len(getMessageContent(anthropicReq.Messages[0].Content)),
-					"output_tokens": 1,
-				},
-			},
-		}
-		sendSSEEvent(w, flusher, "message_start", messageStart)
-		sendSSEEvent(w, flusher, "ping", map[string]string{
-			"type": "ping",
-		})
-
-		contentBlockStart := map[string]any{
-			"content_block": map[string]any{
-				"text": "",
-				"type": "text"},
-			"index": 0, "type": "content_block_start",
-		}
-
-		sendSSEEvent(w, flusher, "content_block_start", contentBlockStart)
-		// 处理解析出的事件
-
-		outputTokens := 0
-		for _, e := range events {
-			sendSSEEvent(w, flusher, e.Event, e.Data)
-
-			if e.Event == "content_block_delta" {
-				outputTokens = len(getMessageContent(e.Data))
-			}
-
-			// 随机延时
-			time.Sleep(time.Duration(rand.Intn(300)) * time.Millisecond)
-		}
-
-		contentBlockStop := map[string]any{
-			"index": 0,
-			"type":  "content_block_stop",
-		}
-		sendSSEEvent(w, flusher, "content_block_stop", contentBlockStop)
-
-		contentBlockStopReason := map[string]any{
-			"type": "message_delta", "delta": map[string]any{"stop_reason": "end_turn", "stop_sequence": nil}, "usage": map[string]any{
-				"output_tokens": outputTokens,
-			},
-		}
-		sendSSEEvent(w, flusher, "message_delta", contentBlockStopReason)
+	if err := checkToolChoice(anthropicReq, translator.StopReason()); err != nil {
+		fmt.Printf("错误: %v\n", err)
+		sendErrorEvent(w, flusher, parser.APIError, err)
+		return
+	}
 
-		messageStop := map[string]any{
-			"type": "message_stop",
-		}
-		sendSSEEvent(w, flusher, "message_stop", messageStop)
+	contentBlockStopReason := map[string]any{
+		"type": "message_delta", "delta": map[string]any{"stop_reason": translator.StopReason(), "stop_sequence": stopSequenceValue(translator.StopSequence())}, "usage": buildUsage(inputTokens, translator),
+	}
+	if metadata := translator.Metadata(); metadata != nil {
+		contentBlockStopReason["metadata"] = metadata
 	}
+	sendSSEEvent(w, flusher, "message_delta", contentBlockStopReason)
 
+	messageStop := map[string]any{
+		"type": "message_stop",
+	}
+	sendSSEEvent(w, flusher, "message_stop", messageStop)
 }
 
 // handleNonStreamRequest 处理非流式请求
 func handleNonStreamRequest(w http.ResponseWriter, anthropicReq AnthropicRequest, accessToken string) {
 	// 构建 CodeWhisperer 请求
-	cwReq := buildCodeWhispererRequest(anthropicReq)
-
-	// 序列化请求体
-	cwReqBody, err := jsonStr.Marshal(cwReq)
+	cwReq, err := buildCodeWhispererRequest(anthropicReq)
 	if err != nil {
-		fmt.Printf("错误: 序列化请求失败: %v\n", err)
-		http.Error(w, fmt.Sprintf("序列化请求失败: %v", err), http.StatusInternalServerError)
+		writeUpstreamError(w, err)
 		return
 	}
 
-	// fmt.Printf("CodeWhisperer 请求体:\n%s\n", string(cwReqBody))
-
-	// 创建请求
-	proxyReq, err := http.NewRequest(
-		http.MethodPost,
-		"https://codewhisperer.us-east-1.amazonaws.com/generateAssistantResponse",
-		bytes.NewBuffer(cwReqBody),
-	)
-	if err != nil {
-		fmt.Printf("错误: 创建代理请求失败: %v\n", err)
-		http.Error(w, fmt.Sprintf("创建代理请求失败: %v", err), http.StatusInternalServerError)
-		return
-	}
-
-	// 设置请求头
-	proxyReq.Header.Set("Authorization", "Bearer "+accessToken)
-	proxyReq.Header.Set("Content-Type", "application/json")
-
 	// 发送请求
-	client := &http.Client{}
-
-	resp, err := client.Do(proxyReq)
+	resp, err := upstreamClient.GenerateAssistantResponse(accessToken, cwReq, false)
 	if err != nil {
 		fmt.Printf("错误: 发送请求失败: %v\n", err)
-		http.Error(w, fmt.Sprintf("发送请求失败: %v", err), http.StatusInternalServerError)
+		writeErrorResponse(w, parser.APIError, fmt.Sprintf("发送请求失败: %v", err))
 		return
 	}
 	defer resp.Body.Close()
@@ -1158,98 +1218,113 @@ func handleNonStreamRequest(w http.ResponseWriter, anthropicReq AnthropicRequest
 	cwRespBody, err := io.ReadAll(resp.Body)
 	if err != nil {
 		fmt.Printf("错误: 读取响应失败: %v\n", err)
-		http.Error(w, fmt.Sprintf("读取响应失败: %v", err), http.StatusInternalServerError)
+		writeErrorResponse(w, parser.APIError, fmt.Sprintf("读取响应失败: %v", err))
+		return
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		fmt.Printf("CodeWhisperer 响应错误，状态码: %d, 响应: %s\n", resp.StatusCode, string(cwRespBody))
+		writeUpstreamError(w, parser.NewHTTPError(resp.StatusCode, cwRespBody))
 		return
 	}
 
 	// fmt.Printf("CodeWhisperer 响应体:\n%s\n", string(cwRespBody))
 
-	respBodyStr := string(cwRespBody)
+	writeAnthropicResponse(w, anthropicReq, cwRespBody)
+}
 
-	events := parser.ParseEvents(cwRespBody)
+// writeAnthropicResponse 将 CodeWhisperer 响应体转换为 Anthropic 响应并写出
+func writeAnthropicResponse(w http.ResponseWriter, anthropicReq AnthropicRequest, cwRespBody []byte) {
+	anthropicResp, err := buildAnthropicResponse(anthropicReq, cwRespBody)
+	if err != nil {
+		fmt.Printf("错误: CodeWhisperer 返回错误: %v\n", err)
+		writeUpstreamError(w, err)
+		return
+	}
 
-	context := ""
-	toolName := ""
-	toolUseId := ""
+	// 发送响应
+	w.Header().Set("Content-Type", "application/json")
+	jsonStr.NewEncoder(w).Encode(anthropicResp)
+}
 
+// buildAnthropicResponse 解析 CodeWhisperer 响应体并构建 Anthropic 响应
+func buildAnthropicResponse(anthropicReq AnthropicRequest, cwRespBody []byte) (map[string]any, error) {
+	respBodyStr := string(cwRespBody)
+
+	translator := newTranslator(anthropicReq)
+	events, err := translator.ParseResponse(cwRespBody)
+	if err != nil {
+		return nil, err
+	}
+
+	// 按 content block 的 index 聚合流式事件
 	contexts := []map[string]any{}
+	partialJson := map[int]string{}
 
-	partialJsonStr := ""
 	for _, event := range events {
-		if event.Data != nil {
-			if dataMap, ok := event.Data.(map[string]any); ok {
-				switch dataMap["type"] {
-				case "content_block_start":
-					context = ""
-				case "content_block_delta":
-					if delta, ok := dataMap["delta"]; ok {
-
-						if deltaMap, ok := delta.(map[string]any); ok {
-							switch deltaMap["type"] {
-							case "text_delta":
-								if text, ok := deltaMap["text"]; ok {
-									context += text.(string)
-								}
-							case "input_json_delta":
-								toolUseId = deltaMap["id"].(string)
-								toolName = deltaMap["name"].(string)
-								if partial_json, ok := deltaMap["partial_json"]; ok {
-									if strPtr, ok := partial_json.(*string); ok && strPtr != nil {
-										partialJsonStr = partialJsonStr + *strPtr
-									} else if str, ok := partial_json.(string); ok {
-										partialJsonStr = partialJsonStr + str
-									} else {
-										log.Println("partial_json is not string or *string")
-									}
-								} else {
-									log.Println("partial_json not found")
-								}
-
-							}
-						}
-					}
+		dataMap, ok := event.Data.(map[string]any)
+		if !ok {
+			continue
+		}
+		index, _ := dataMap["index"].(int)
 
-				case "content_block_stop":
-					if index, ok := dataMap["index"]; ok {
-						switch index {
-						case 1:
-							toolInput := map[string]interface{}{}
-							if err := jsonStr.Unmarshal([]byte(partialJsonStr), &toolInput); err != nil {
-								log.Printf("json unmarshal error:%s", err.Error())
-							}
-
-							contexts = append(contexts, map[string]interface{}{
-								"type":  "tool_use",
-								"id":    toolUseId,
-								"name":  toolName,
-								"input": toolInput,
-							})
-						case 0:
-							contexts = append(contexts, map[string]interface{}{
-								"text": context,
-								"type": "text",
-							})
-						}
-					}
+		switch dataMap["type"] {
+		case "content_block_start":
+			if block, ok := dataMap["content_block"].(map[string]any); ok {
+				contexts = append(contexts, block)
+			}
+		case "content_block_delta":
+			if index >= len(contexts) {
+				continue
+			}
+			deltaMap, ok := dataMap["delta"].(map[string]any)
+			if !ok {
+				continue
+			}
+			switch deltaMap["type"] {
+			case "text_delta":
+				if text, ok := deltaMap["text"].(string); ok {
+					contexts[index]["text"] = contexts[index]["text"].(string) + text
 				}
-
+			case "input_json_delta":
+				if partial, ok := deltaMap["partial_json"].(string); ok {
+					partialJson[index] += partial
+				}
+			case "thinking_delta":
+				if thinking, ok := deltaMap["thinking"].(string); ok {
+					contexts[index]["thinking"] = contexts[index]["thinking"].(string) + thinking
+				}
+			case "signature_delta":
+				if signature, ok := deltaMap["signature"].(string); ok {
+					contexts[index]["signature"] = signature
+				}
+			}
+		case "content_block_stop":
+			if index >= len(contexts) || contexts[index]["type"] != "tool_use" {
+				continue
 			}
+			toolInput := map[string]interface{}{}
+			if partial := partialJson[index]; partial != "" {
+				if err := jsonStr.Unmarshal([]byte(partial), &toolInput); err != nil {
+					log.Printf("json unmarshal error:%s", err.Error())
+				}
+			}
+			contexts[index]["input"] = toolInput
 		}
 	}
 
-	// 回退：如果已累积到文本但未收到 content_block_stop(index=0)，也要返回文本
-	if len(contexts) == 0 && strings.TrimSpace(context) != "" {
-		contexts = append(contexts, map[string]any{
-			"type": "text",
-			"text": context,
-		})
-	}
-	
 	// 检查是否是错误响应
 	if strings.Contains(string(cwRespBody), "Improperly formed request.") {
-		fmt.Printf("错误: CodeWhisperer返回格式错误: %s\n", respBodyStr)
-		http.Error(w, fmt.Sprintf("请求格式错误: %s", respBodyStr), http.StatusBadRequest)
-		return
+		return nil, &parser.UpstreamError{
+			ExceptionType: "ValidationException",
+			Message:       fmt.Sprintf("请求格式错误: %s", respBodyStr),
+		}
+	}
+
+	usage := buildUsage(countInputTokens(anthropicReq), translator)
+
+	if err := checkToolChoice(anthropicReq, translator.StopReason()); err != nil {
+		return nil, err
 	}
 
 	// 构建 Anthropic 响应
@@ -1257,18 +1332,32 @@ func handleNonStreamRequest(w http.ResponseWriter, anthropicReq AnthropicRequest
 		"content":       contexts,
 		"model":         anthropicReq.Model,
 		"role":          "assistant",
-		"stop_reason":   "end_turn",
-		"stop_sequence": nil,
+		"stop_reason":   translator.StopReason(),
+		"stop_sequence": stopSequenceValue(translator.StopSequence()),
 		"type":          "message",
-		"usage": map[string]any{
-			"input_tokens":  len(cwReq.ConversationState.CurrentMessage.UserInputMessage.Content),
-			"output_tokens": len(context),
-		},
+		"usage":         usage,
+	}
+	if metadata := translator.Metadata(); metadata != nil {
+		anthropicResp["metadata"] = metadata
 	}
 
-	// 发送响应
-	w.Header().Set("Content-Type", "application/json")
-	jsonStr.NewEncoder(w).Encode(anthropicResp)
+	return anthropicResp, nil
+}
+
+// newTranslator 创建按配置限制帧大小的事件流转换器，未开启思考的请求不输出 thinking 块
+func newTranslator(anthropicReq AnthropicRequest) *parser.Translator {
+	translator := parser.NewTranslator()
+	if config.Parser.MaxFrameSize > 0 {
+		translator.MaxFrameSize = uint32(config.Parser.MaxFrameSize)
+	}
+	translator.DropThinking = !thinkingEnabled(anthropicReq)
+	translator.StopSequences = anthropicReq.StopSequences
+	translator.AllowTool = allowTool(anthropicReq)
+	translator.MaxToolUses = maxToolUses(anthropicReq)
+	// CodeWhisperer 不接受输出长度限制，max_tokens 由转换器计数并截断
+	translator.MaxTokens = anthropicReq.MaxTokens
+	translator.CountTokens = tokenizer.Count
+	return translator
 }
 
 // sendSSEEvent 发送 SSE 事件
@@ -1289,18 +1378,44 @@ func sendSSEEvent(w http.ResponseWriter, flusher http.Flusher, eventType string,
 }
 
 // sendErrorEvent 发送错误事件
-func sendErrorEvent(w http.ResponseWriter, flusher http.Flusher, message string, err error) {
-	errorResp := map[string]any{
-		"type": "error",
-		"error": map[string]any{
-			"type":    "overloaded_error",
-			"message": message,
-		},
+func sendErrorEvent(w http.ResponseWriter, flusher http.Flusher, errorType string, err error) {
+	// data: {"type": "error", "error": {"type": "overloaded_error", "message": "Overloaded"}}
+	e := parser.ErrorEvent(errorType, err.Error())
+	sendSSEEvent(w, flusher, e.Event, e.Data)
+}
+
+// writeErrorResponse 以 Anthropic 错误格式返回 JSON 错误响应
+func writeErrorResponse(w http.ResponseWriter, errorType string, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(parser.StatusForErrorType(errorType))
+	jsonStr.NewEncoder(w).Encode(parser.ErrorEvent(errorType, message).Data)
+}
+
+// writeUpstreamError 将上游错误转换为对应状态码的 JSON 错误响应
+func writeUpstreamError(w http.ResponseWriter, err error) {
+	writeErrorResponse(w, upstreamErrorType(err), err.Error())
+}
+
+// upstreamErrorType 返回错误对应的 Anthropic error.type
+func upstreamErrorType(err error) string {
+	var upErr *parser.UpstreamError
+	if errors.As(err, &upErr) {
+		return upErr.ErrorType()
+	}
+	var reqErr *invalidRequestError
+	if errors.As(err, &reqErr) {
+		return parser.InvalidRequestError
 	}
+	return parser.APIError
+}
 
-	// data: {"type": "error", "error": {"type": "overloaded_error", "message": "Overloaded"}}
+// invalidRequestError 表示客户端请求本身不合法，不会发往上游
+type invalidRequestError struct {
+	message string
+}
 
-	sendSSEEvent(w, flusher, "error", errorResp)
+func (e *invalidRequestError) Error() string {
+	return e.message
 }
 
 func FileExists(path string) (bool, error) {
@@ -0,0 +1,229 @@
+// Package mockupstream is a local stand-in for the CodeWhisperer
+// generateAssistantResponse endpoint. It replies with scripted AWS
+// event-stream responses so the proxy can be developed and tested offline.
+//
+// The scenario is picked per request: a "[mock:<name>]" marker anywhere in
+// the current user message wins, otherwise Server.Default is used.
+package mockupstream
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bestk/kiro2cc/parser"
+)
+
+// Request is the subset of a generateAssistantResponse request the mock
+// looks at.
+type Request struct {
+	ConversationState struct {
+		ConversationId string `json:"conversationId"`
+		CurrentMessage struct {
+			UserInputMessage struct {
+				Content string `json:"content"`
+				ModelId string `json:"modelId"`
+				Images  []struct {
+					Format string `json:"format"`
+				} `json:"images"`
+				UserInputMessageContext struct {
+					Tools []struct {
+						ToolSpecification struct {
+							Name string `json:"name"`
+						} `json:"toolSpecification"`
+					} `json:"tools"`
+					ToolResults []struct {
+						ToolUseId string `json:"toolUseId"`
+						Status    string `json:"status"`
+					} `json:"toolResults"`
+				} `json:"userInputMessageContext"`
+			} `json:"userInputMessage"`
+		} `json:"currentMessage"`
+		History []json.RawMessage `json:"history"`
+	} `json:"conversationState"`
+	ProfileArn string `json:"profileArn"`
+}
+
+// Content returns the current user message.
+func (r *Request) Content() string {
+	return r.ConversationState.CurrentMessage.UserInputMessage.Content
+}
+
+// ToolNames returns the names of the tools offered in the request.
+func (r *Request) ToolNames() []string {
+	var names []string
+	for _, t := range r.ConversationState.CurrentMessage.UserInputMessage.UserInputMessageContext.Tools {
+		names = append(names, t.ToolSpecification.Name)
+	}
+	return names
+}
+
+// Step is one scripted frame of a response. Exactly one of Event or
+// Exception is set; Delay is slept before the frame is written.
+type Step struct {
+	Delay     time.Duration
+	Event     string // :event-type of an event frame
+	Payload   any    // JSON payload of the event frame
+	Exception string // :exception-type of an exception frame
+	Message   string // exception message
+
+	// Partial writes only the first half of the frame and ends the
+	// response, like a connection dropped mid-stream.
+	Partial bool
+}
+
+// Response is what a Scenario answers. A non-zero Status other than 200
+// is sent as a plain JSON error reply with Body; otherwise Steps are
+// streamed as event-stream frames.
+type Response struct {
+	Status int
+	Body   string
+	Steps  []Step
+}
+
+// Scenario scripts the response to a request.
+type Scenario func(req *Request) Response
+
+// Server serves scripted CodeWhisperer responses. It records every request
+// it receives so tests can assert on what the proxy sent.
+type Server struct {
+	Default   string
+	Scenarios map[string]Scenario
+
+	mu       sync.Mutex
+	requests []Request
+}
+
+var markerPattern = regexp.MustCompile(`\[mock:([a-z_]+)\]`)
+
+// New returns a Server with the built-in scenarios and "text" as default.
+func New() *Server {
+	return &Server{
+		Default:   "text",
+		Scenarios: DefaultScenarios(),
+	}
+}
+
+// NewTestServer starts a Server on a random local port. Point the proxy at
+// ts.URL + "/generateAssistantResponse" and Close ts when done.
+func NewTestServer() (*httptest.Server, *Server) {
+	s := New()
+	return httptest.NewServer(s), s
+}
+
+// Requests returns the requests received so far.
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+// ScenarioNames returns the registered scenario names, sorted.
+func (s *Server) ScenarioNames() []string {
+	names := make([]string, 0, len(s.Scenarios))
+	for name := range s.Scenarios {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || !strings.HasSuffix(r.URL.Path, "/generateAssistantResponse") {
+		writeException(w, http.StatusNotFound, "UnknownOperationException", "mock upstream only serves POST /generateAssistantResponse")
+		return
+	}
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") {
+		writeException(w, http.StatusForbidden, "AccessDeniedException", "missing bearer token")
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeException(w, http.StatusBadRequest, "SerializationException", err.Error())
+		return
+	}
+	var req Request
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeException(w, http.StatusBadRequest, "SerializationException", err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	s.requests = append(s.requests, req)
+	s.mu.Unlock()
+
+	name := s.Default
+	if m := markerPattern.FindStringSubmatch(req.Content()); m != nil {
+		name = m[1]
+	}
+	scenario, ok := s.Scenarios[name]
+	if !ok {
+		writeException(w, http.StatusBadRequest, "ValidationException", fmt.Sprintf("unknown mock scenario %q", name))
+		return
+	}
+
+	log.Printf("mock upstream: scenario %q", name)
+	resp := scenario(&req)
+	if resp.Status != 0 && resp.Status != http.StatusOK {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(resp.Status)
+		io.WriteString(w, resp.Body)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/vnd.amazon.eventstream")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	for _, step := range resp.Steps {
+		if step.Delay > 0 {
+			if flusher != nil {
+				flusher.Flush()
+			}
+			select {
+			case <-time.After(step.Delay):
+			case <-r.Context().Done():
+				return
+			}
+		}
+
+		var buf bytes.Buffer
+		enc := parser.NewEncoder(&buf)
+		if step.Exception != "" {
+			err = enc.EncodeException(step.Exception, step.Message)
+		} else {
+			err = enc.EncodeEvent(step.Event, step.Payload)
+		}
+		if err != nil {
+			log.Printf("mock upstream: encode failed: %v", err)
+			return
+		}
+
+		frame := buf.Bytes()
+		if step.Partial {
+			w.Write(frame[:len(frame)/2])
+			return
+		}
+		if _, err := w.Write(frame); err != nil {
+			return
+		}
+	}
+}
+
+func writeException(w http.ResponseWriter, status int, exceptionType, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{
+		"__type":  "com.amazon.aws.codewhisperer#" + exceptionType,
+		"message": message,
+	})
+}
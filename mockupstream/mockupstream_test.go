@@ -0,0 +1,109 @@
+package mockupstream
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/bestk/kiro2cc/parser"
+)
+
+func post(t *testing.T, url, content string) *http.Response {
+	t.Helper()
+	body := `{"conversationState":{"currentMessage":{"userInputMessage":{"content":` +
+		`"` + content + `","userInputMessageContext":{"tools":[{"toolSpecification":{"name":"Bash"}}]}}}}}`
+	req, err := http.NewRequest(http.MethodPost, url+"/generateAssistantResponse", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer test")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func TestScenarios(t *testing.T) {
+	ts, srv := NewTestServer()
+	defer ts.Close()
+
+	tests := []struct {
+		content    string
+		status     int
+		stopReason string
+		wantText   string
+		wantErr    string // Anthropic error type from the stream
+	}{
+		{content: "hello", status: 200, stopReason: "end_turn", wantText: "Mock response to: hello"},
+		{content: "[mock:tool] go", status: 200, stopReason: "tool_use", wantText: "Let me check that."},
+		{content: "[mock:exception]", status: 200, wantErr: parser.RateLimitError},
+		{content: "[mock:invalid]", status: 200, wantErr: parser.InvalidRequestError},
+		{content: "[mock:truncated]", status: 200, wantText: "This answer is cut"},
+		{content: "[mock:throttle]", status: 429},
+		{content: "[mock:expired]", status: 403},
+		{content: "[mock:nope]", status: 400},
+	}
+
+	for _, tt := range tests {
+		resp := post(t, ts.URL, tt.content)
+		if resp.StatusCode != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.content, resp.StatusCode, tt.status)
+			continue
+		}
+		if resp.StatusCode != http.StatusOK {
+			continue
+		}
+
+		tr := parser.NewTranslator()
+		var text strings.Builder
+		err := tr.Stream(resp.Body, func(e parser.SSEEvent) {
+			if data, ok := e.Data.(map[string]any); ok && e.Event == "content_block_delta" {
+				if delta, ok := data["delta"].(map[string]any); ok && delta["type"] == "text_delta" {
+					text.WriteString(delta["text"].(string))
+				}
+			}
+		})
+
+		var upstreamErr *parser.UpstreamError
+		switch {
+		case tt.wantErr != "":
+			if !errors.As(err, &upstreamErr) || upstreamErr.ErrorType() != tt.wantErr {
+				t.Errorf("%s: got error %v, want %s", tt.content, err, tt.wantErr)
+			}
+		case tt.content == "[mock:truncated]":
+			if !errors.Is(err, parser.ErrTruncatedFrame) {
+				t.Errorf("%s: got error %v, want ErrTruncatedFrame", tt.content, err)
+			}
+		case err != nil:
+			t.Errorf("%s: unexpected error %v", tt.content, err)
+		case tr.StopReason() != tt.stopReason:
+			t.Errorf("%s: stop reason %q, want %q", tt.content, tr.StopReason(), tt.stopReason)
+		}
+		if tt.wantText != "" && text.String() != tt.wantText {
+			t.Errorf("%s: text %q, want %q", tt.content, text.String(), tt.wantText)
+		}
+	}
+
+	if got := len(srv.Requests()); got != len(tests) {
+		t.Errorf("recorded %d requests, want %d", got, len(tests))
+	}
+}
+
+func TestRequiresBearerToken(t *testing.T) {
+	ts, _ := NewTestServer()
+	defer ts.Close()
+
+	resp, err := http.Post(ts.URL+"/generateAssistantResponse", "application/json", strings.NewReader("{}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("status %d, want 403", resp.StatusCode)
+	}
+}
@@ -0,0 +1,164 @@
+package mockupstream
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bestk/kiro2cc/parser"
+)
+
+// DefaultScenarios returns the built-in scenarios:
+//
+//	text        echoes the user message back in a few chunks
+//	tool        calls the first offered tool (or "Read") with streamed input
+//	tools       two tool calls back to back; both tool scenarios answer
+//	            with text once the request carries tool results
+//	thinking    a signed reasoning block followed by the text reply
+//	exception   some text, then a mid-stream ThrottlingException frame
+//	invalid     a ValidationException frame before any content
+//	throttle    HTTP 429 ThrottlingException
+//	expired     HTTP 403, as returned for an expired access token
+//	server      HTTP 500 InternalServerException
+//	slow        text chunks with a pause before each one
+//	truncated   a text chunk followed by half a frame
+func DefaultScenarios() map[string]Scenario {
+	return map[string]Scenario{
+		"text":      textScenario(0),
+		"slow":      textScenario(500 * time.Millisecond),
+		"tool":      toolScenario(1),
+		"tools":     toolScenario(2),
+		"thinking":  thinkingScenario,
+		"exception": exceptionScenario,
+		"invalid":   invalidScenario,
+		"throttle":  httpErrorScenario(http.StatusTooManyRequests, "ThrottlingException", "Too many requests, please wait before trying again."),
+		"expired":   httpErrorScenario(http.StatusForbidden, "AccessDeniedException", "The bearer token included in the request is invalid."),
+		"server":    httpErrorScenario(http.StatusInternalServerError, "InternalServerException", "Encountered an unexpected error when processing the request, please try again."),
+		"truncated": truncatedScenario,
+	}
+}
+
+// Text returns a step that emits one assistantResponseEvent.
+func Text(content string) Step {
+	return Step{Event: parser.EventAssistantResponse, Payload: parser.AssistantResponseEvent{Content: content}}
+}
+
+// ToolUse returns the steps of one tool call: start, input in chunks, stop.
+func ToolUse(id, name string, inputChunks ...string) []Step {
+	steps := []Step{{Event: parser.EventToolUse, Payload: parser.ToolUseEvent{Name: name, ToolUseId: id}}}
+	for _, chunk := range inputChunks {
+		chunk := chunk
+		steps = append(steps, Step{Event: parser.EventToolUse, Payload: parser.ToolUseEvent{Name: name, ToolUseId: id, Input: &chunk}})
+	}
+	return append(steps, Step{Event: parser.EventToolUse, Payload: parser.ToolUseEvent{Name: name, ToolUseId: id, Stop: true}})
+}
+
+// Exception returns a step that emits an exception frame.
+func Exception(exceptionType, message string) Step {
+	return Step{Exception: exceptionType, Message: message}
+}
+
+// trailer is the metadata CodeWhisperer sends after the content.
+func trailer() []Step {
+	return []Step{
+		{Event: parser.EventMetering, Payload: parser.MeteringEvent{Unit: "credit", UnitPlural: "credits", Usage: 0.01}},
+		{Event: parser.EventContextUsage, Payload: parser.ContextUsageEvent{ContextUsagePercentage: 1.5}},
+	}
+}
+
+func metadata(req *Request) Step {
+	id := req.ConversationState.ConversationId
+	if id == "" {
+		id = "mock-conversation"
+	}
+	return Step{Event: parser.EventMessageMetadata, Payload: parser.MessageMetadataEvent{ConversationId: id}}
+}
+
+func textScenario(delay time.Duration) Scenario {
+	return func(req *Request) Response {
+		reply := fmt.Sprintf("Mock response to: %s", strings.TrimSpace(markerPattern.ReplaceAllString(req.Content(), "")))
+		if n := len(req.ConversationState.CurrentMessage.UserInputMessage.Images); n > 0 {
+			reply += fmt.Sprintf(" (%d image(s) attached)", n)
+		}
+		steps := []Step{metadata(req)}
+		for _, chunk := range chunks(reply, 16) {
+			step := Text(chunk)
+			step.Delay = delay
+			steps = append(steps, step)
+		}
+		return Response{Steps: append(steps, trailer()...)}
+	}
+}
+
+func toolScenario(calls int) Scenario {
+	return func(req *Request) Response {
+		if results := req.ConversationState.CurrentMessage.UserInputMessage.UserInputMessageContext.ToolResults; len(results) > 0 {
+			steps := []Step{metadata(req), Text(fmt.Sprintf("Received %d tool result(s).", len(results)))}
+			return Response{Steps: append(steps, trailer()...)}
+		}
+
+		name := "Read"
+		if names := req.ToolNames(); len(names) > 0 {
+			name = names[0]
+		}
+		steps := []Step{metadata(req), Text("Let me check that.")}
+		for i := 1; i <= calls; i++ {
+			steps = append(steps, ToolUse(fmt.Sprintf("tooluse_mock%d", i), name, `{"path":`, fmt.Sprintf(`"file%d.txt"}`, i))...)
+		}
+		return Response{Steps: append(steps, trailer()...)}
+	}
+}
+
+func thinkingScenario(req *Request) Response {
+	steps := []Step{
+		metadata(req),
+		{Event: parser.EventReasoningContent, Payload: parser.ReasoningContentEvent{Text: "Let me think about "}},
+		{Event: parser.EventReasoningContent, Payload: parser.ReasoningContentEvent{Text: "this request."}},
+		{Event: parser.EventReasoningContent, Payload: parser.ReasoningContentEvent{Signature: "mock-signature"}},
+		Text("Here is my answer."),
+	}
+	return Response{Steps: append(steps, trailer()...)}
+}
+
+func exceptionScenario(req *Request) Response {
+	return Response{Steps: []Step{
+		metadata(req),
+		Text("Partial answer"),
+		Exception("ThrottlingException", "Too many requests, please wait before trying again."),
+	}}
+}
+
+func invalidScenario(req *Request) Response {
+	return Response{Steps: []Step{
+		Exception("ValidationException", "Improperly formed request."),
+	}}
+}
+
+func httpErrorScenario(status int, exceptionType, message string) Scenario {
+	return func(req *Request) Response {
+		return Response{
+			Status: status,
+			Body:   fmt.Sprintf(`{"__type":"com.amazon.aws.codewhisperer#%s","message":%q}`, exceptionType, message),
+		}
+	}
+}
+
+func truncatedScenario(req *Request) Response {
+	return Response{Steps: []Step{
+		metadata(req),
+		Text("This answer is cut"),
+		{Event: parser.EventAssistantResponse, Payload: parser.AssistantResponseEvent{Content: " short"}, Partial: true},
+	}}
+}
+
+// chunks splits s into pieces of at most n runes.
+func chunks(s string, n int) []string {
+	var out []string
+	runes := []rune(s)
+	for len(runes) > n {
+		out = append(out, string(runes[:n]))
+		runes = runes[n:]
+	}
+	return append(out, string(runes))
+}
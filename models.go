@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bestk/kiro2cc/parser"
+)
+
+// 模型列表分页参数，与 Anthropic API 一致
+const (
+	defaultModelsLimit = 20
+	maxModelsLimit     = 1000
+)
+
+// modelDatePattern 匹配模型 ID 末尾的发布日期，例如 claude-sonnet-4-20250514
+var modelDatePattern = regexp.MustCompile(`-(\d{8})$`)
+
+// AnthropicModel 表示 /v1/models 返回的模型对象
+type AnthropicModel struct {
+	Type        string `json:"type"`
+	ID          string `json:"id"`
+	DisplayName string `json:"display_name"`
+	CreatedAt   string `json:"created_at"`
+}
+
+// AnthropicModelList 表示 /v1/models 的分页响应
+type AnthropicModelList struct {
+	Data    []AnthropicModel `json:"data"`
+	FirstID *string          `json:"first_id"`
+	LastID  *string          `json:"last_id"`
+	HasMore bool             `json:"has_more"`
+}
+
+// listModels 根据 ModelMap 生成模型列表，按发布日期从新到旧排列，日期相同按 ID 排序
+func listModels() []AnthropicModel {
+	models := make([]AnthropicModel, 0, len(ModelMap))
+	for id := range ModelMap {
+		models = append(models, newAnthropicModel(id))
+	}
+	sort.Slice(models, func(i, j int) bool {
+		if models[i].CreatedAt != models[j].CreatedAt {
+			return models[i].CreatedAt > models[j].CreatedAt
+		}
+		return models[i].ID < models[j].ID
+	})
+	return models
+}
+
+// newAnthropicModel 从模型 ID 推导展示名称和发布时间
+func newAnthropicModel(id string) AnthropicModel {
+	model := AnthropicModel{
+		Type:        "model",
+		ID:          id,
+		DisplayName: modelDisplayName(id),
+		CreatedAt:   time.Unix(0, 0).UTC().Format(time.RFC3339),
+	}
+	if m := modelDatePattern.FindStringSubmatch(id); m != nil {
+		if date, err := time.Parse("20060102", m[1]); err == nil {
+			model.CreatedAt = date.Format(time.RFC3339)
+		}
+	}
+	return model
+}
+
+// modelDisplayName 按 Anthropic 的命名方式生成展示名称：
+// claude-3-5-haiku-20241022 -> Claude Haiku 3.5，claude-sonnet-4-20250514 -> Claude Sonnet 4
+func modelDisplayName(id string) string {
+	var names, versions []string
+	for _, part := range strings.Split(modelDatePattern.ReplaceAllString(id, ""), "-") {
+		if part == "" {
+			continue
+		}
+		if _, err := strconv.Atoi(part); err == nil {
+			versions = append(versions, part)
+			continue
+		}
+		names = append(names, strings.ToUpper(part[:1])+part[1:])
+	}
+
+	// 版本号放在系列名之后
+	if len(versions) > 0 {
+		names = append(names, strings.Join(versions, "."))
+	}
+	return strings.Join(names, " ")
+}
+
+// handleModels 处理 GET /v1/models 和 GET /v1/models/{id}
+func handleModels(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeErrorResponse(w, parser.InvalidRequestError, "只支持GET请求")
+		return
+	}
+
+	if id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/v1/models"), "/"); id != "" {
+		if _, ok := ModelMap[id]; !ok {
+			writeErrorResponse(w, parser.NotFoundError, fmt.Sprintf("model: %s", id))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(newAnthropicModel(id))
+		return
+	}
+
+	list, err := paginateModels(listModels(), r.URL.Query().Get("before_id"), r.URL.Query().Get("after_id"), r.URL.Query().Get("limit"))
+	if err != nil {
+		writeUpstreamError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// paginateModels 按 before_id、after_id 和 limit 截取模型列表。
+// after_id 返回该模型之后的一页，before_id 返回紧挨在该模型之前的一页
+func paginateModels(models []AnthropicModel, beforeID, afterID, limitParam string) (AnthropicModelList, error) {
+	limit := defaultModelsLimit
+	if limitParam != "" {
+		n, err := strconv.Atoi(limitParam)
+		if err != nil || n < 1 || n > maxModelsLimit {
+			return AnthropicModelList{}, &invalidRequestError{fmt.Sprintf("limit: must be an integer between 1 and %d", maxModelsLimit)}
+		}
+		limit = n
+	}
+	if beforeID != "" && afterID != "" {
+		return AnthropicModelList{}, &invalidRequestError{"before_id and after_id cannot be used together"}
+	}
+
+	indexOf := func(id string) (int, error) {
+		for i, m := range models {
+			if m.ID == id {
+				return i, nil
+			}
+		}
+		return 0, &invalidRequestError{fmt.Sprintf("model %q not found", id)}
+	}
+
+	start, end := 0, len(models)
+	switch {
+	case afterID != "":
+		i, err := indexOf(afterID)
+		if err != nil {
+			return AnthropicModelList{}, err
+		}
+		start = i + 1
+		end = min(start+limit, len(models))
+	case beforeID != "":
+		i, err := indexOf(beforeID)
+		if err != nil {
+			return AnthropicModelList{}, err
+		}
+		end = i
+		start = max(end-limit, 0)
+	default:
+		end = min(limit, len(models))
+	}
+
+	list := AnthropicModelList{Data: models[start:end]}
+	if beforeID != "" {
+		list.HasMore = start > 0
+	} else {
+		list.HasMore = end < len(models)
+	}
+	if len(list.Data) > 0 {
+		list.FirstID = &list.Data[0].ID
+		list.LastID = &list.Data[len(list.Data)-1].ID
+	}
+	return list, nil
+}
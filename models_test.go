@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNewAnthropicModel(t *testing.T) {
+	tests := []struct {
+		id          string
+		displayName string
+		createdAt   string
+	}{
+		{"claude-sonnet-4-20250514", "Claude Sonnet 4", "2025-05-14T00:00:00Z"},
+		{"claude-3-5-haiku-20241022", "Claude Haiku 3.5", "2024-10-22T00:00:00Z"},
+		{"claude-opus-4-1", "Claude Opus 4.1", "1970-01-01T00:00:00Z"},
+	}
+
+	for _, tt := range tests {
+		got := newAnthropicModel(tt.id)
+		if got.Type != "model" || got.DisplayName != tt.displayName || got.CreatedAt != tt.createdAt {
+			t.Errorf("newAnthropicModel(%q) = %+v, want display name %q created at %s", tt.id, got, tt.displayName, tt.createdAt)
+		}
+	}
+}
+
+func TestPaginateModels(t *testing.T) {
+	var models []AnthropicModel
+	for _, id := range []string{"m1", "m2", "m3", "m4", "m5"} {
+		models = append(models, AnthropicModel{Type: "model", ID: id})
+	}
+
+	tests := []struct {
+		name          string
+		before, after string
+		limit         string
+		want          []string
+		hasMore       bool
+	}{
+		{"default", "", "", "", []string{"m1", "m2", "m3", "m4", "m5"}, false},
+		{"first page", "", "", "2", []string{"m1", "m2"}, true},
+		{"after", "", "m2", "2", []string{"m3", "m4"}, true},
+		{"last page", "", "m3", "2", []string{"m4", "m5"}, false},
+		{"before", "m4", "", "2", []string{"m2", "m3"}, true},
+		{"before first page", "m3", "", "5", []string{"m1", "m2"}, false},
+	}
+
+	for _, tt := range tests {
+		list, err := paginateModels(models, tt.before, tt.after, tt.limit)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		var ids []string
+		for _, m := range list.Data {
+			ids = append(ids, m.ID)
+		}
+		if len(ids) != len(tt.want) || (len(ids) > 0 && (ids[0] != tt.want[0] || ids[len(ids)-1] != tt.want[len(tt.want)-1])) {
+			t.Errorf("%s: got %v, want %v", tt.name, ids, tt.want)
+		}
+		if list.HasMore != tt.hasMore {
+			t.Errorf("%s: has_more = %v, want %v", tt.name, list.HasMore, tt.hasMore)
+		}
+		if *list.FirstID != tt.want[0] || *list.LastID != tt.want[len(tt.want)-1] {
+			t.Errorf("%s: first_id/last_id = %s/%s", tt.name, *list.FirstID, *list.LastID)
+		}
+	}
+
+	for _, bad := range [][3]string{{"", "", "0"}, {"", "", "abc"}, {"m1", "m2", ""}, {"", "nope", ""}} {
+		_, err := paginateModels(models, bad[0], bad[1], bad[2])
+		var reqErr *invalidRequestError
+		if !errors.As(err, &reqErr) {
+			t.Errorf("before_id=%q after_id=%q limit=%q: got %v, want *invalidRequestError", bad[0], bad[1], bad[2], err)
+		}
+	}
+}
+
+func TestHandleModels(t *testing.T) {
+	rec := httptest.NewRecorder()
+	handleModels(rec, httptest.NewRequest(http.MethodGet, "/v1/models", nil))
+	var list AnthropicModelList
+	if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Data) != len(ModelMap) {
+		t.Errorf("listed %d models, ModelMap has %d", len(list.Data), len(ModelMap))
+	}
+	for i := 1; i < len(list.Data); i++ {
+		if list.Data[i-1].CreatedAt < list.Data[i].CreatedAt {
+			t.Errorf("models not sorted newest first: %v", list.Data)
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	handleModels(rec, httptest.NewRequest(http.MethodGet, "/v1/models/claude-sonnet-4-20250514", nil))
+	var model AnthropicModel
+	if err := json.Unmarshal(rec.Body.Bytes(), &model); err != nil {
+		t.Fatal(err)
+	}
+	if model.ID != "claude-sonnet-4-20250514" || model.DisplayName != "Claude Sonnet 4" {
+		t.Errorf("got %+v", model)
+	}
+
+	rec = httptest.NewRecorder()
+	handleModels(rec, httptest.NewRequest(http.MethodGet, "/v1/models/gpt-4", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("unknown model: status = %d, want 404", rec.Code)
+	}
+}
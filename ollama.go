@@ -0,0 +1,494 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bestk/kiro2cc/parser"
+)
+
+// ollamaLatestTag Ollama 客户端常在模型名后加 :latest，请求时去掉
+const ollamaLatestTag = ":latest"
+
+// OllamaChatRequest 表示 Ollama /api/chat 的请求结构
+type OllamaChatRequest struct {
+	Model     string          `json:"model"`
+	Messages  []OllamaMessage `json:"messages"`
+	Tools     []OpenAITool    `json:"tools,omitempty"`
+	Format    any             `json:"format,omitempty"` // "json" 或 JSON schema
+	Options   *OllamaOptions  `json:"options,omitempty"`
+	Stream    *bool           `json:"stream,omitempty"` // 默认流式
+	Think     *bool           `json:"think,omitempty"`
+	KeepAlive any             `json:"keep_alive,omitempty"`
+}
+
+// OllamaGenerateRequest 表示 Ollama /api/generate 的请求结构
+type OllamaGenerateRequest struct {
+	Model     string         `json:"model"`
+	Prompt    string         `json:"prompt"`
+	Suffix    string         `json:"suffix,omitempty"`
+	System    string         `json:"system,omitempty"`
+	Images    []string       `json:"images,omitempty"`
+	Format    any            `json:"format,omitempty"`
+	Options   *OllamaOptions `json:"options,omitempty"`
+	Stream    *bool          `json:"stream,omitempty"`
+	Think     *bool          `json:"think,omitempty"`
+	Raw       bool           `json:"raw,omitempty"`     // 没有模板可跳过，忽略
+	Context   []int          `json:"context,omitempty"` // 已废弃，忽略
+	KeepAlive any            `json:"keep_alive,omitempty"`
+}
+
+// OllamaMessage 表示 Ollama 的一条消息，图片为不带 media type 的 base64
+type OllamaMessage struct {
+	Role      string           `json:"role"`
+	Content   string           `json:"content"`
+	Thinking  string           `json:"thinking,omitempty"`
+	Images    []string         `json:"images,omitempty"`
+	ToolCalls []OllamaToolCall `json:"tool_calls,omitempty"`
+	ToolName  string           `json:"tool_name,omitempty"`
+}
+
+// OllamaToolCall 表示一次工具调用，Ollama 的工具调用没有 id，参数是 JSON 对象
+type OllamaToolCall struct {
+	Function struct {
+		Name      string         `json:"name"`
+		Arguments map[string]any `json:"arguments"`
+	} `json:"function"`
+}
+
+// OllamaOptions 表示 options 中可以转发的采样参数，其余模型参数忽略
+type OllamaOptions struct {
+	Temperature *float64 `json:"temperature,omitempty"`
+	TopP        *float64 `json:"top_p,omitempty"`
+	TopK        *int     `json:"top_k,omitempty"`
+	NumPredict  *int     `json:"num_predict,omitempty"` // -1 和 -2 表示不限制
+	Stop        []string `json:"stop,omitempty"`
+}
+
+// apply 把 options 和 format、think 写入 AnthropicRequest
+func (opts *OllamaOptions) apply(req *AnthropicRequest, format any, think *bool) error {
+	if opts != nil {
+		if opts.Temperature != nil {
+			// Ollama 不限制上界，常见取值 0 到 1 与 Anthropic 一致，更高的值按 1 处理
+			temperature := min(*opts.Temperature, 1)
+			req.Temperature = &temperature
+		}
+		req.TopP = opts.TopP
+		req.TopK = opts.TopK
+		if opts.NumPredict != nil && *opts.NumPredict > 0 {
+			req.MaxTokens = *opts.NumPredict
+		}
+		req.StopSequences = opts.Stop
+	}
+
+	if think != nil && *think {
+		req.Thinking = &AnthropicThinking{Type: "enabled", BudgetTokens: minThinkingBudget}
+	}
+
+	var instruction string
+	switch f := format.(type) {
+	case nil:
+	case string:
+		if f == "" {
+			break
+		}
+		if f != "json" {
+			return &invalidRequestError{fmt.Sprintf("format: unsupported value %q", f)}
+		}
+		instruction = jsonResponseInstruction(nil)
+	case map[string]interface{}:
+		instruction = jsonResponseInstruction(f)
+	default:
+		return &invalidRequestError{"format: must be \"json\" or a JSON schema"}
+	}
+	if instruction != "" {
+		req.System = append(req.System, AnthropicSystemMessage{Type: "text", Text: instruction})
+	}
+	return nil
+}
+
+// ollamaModel 去掉模型名中的 :latest 标签
+func ollamaModel(name string) string {
+	return strings.TrimSuffix(name, ollamaLatestTag)
+}
+
+// ollamaImageBlocks 把 Ollama 的 base64 图片转换为 image 内容块，media type 按内容识别
+func ollamaImageBlocks(images []string) ([]interface{}, error) {
+	var blocks []interface{}
+	for i, img := range images {
+		data, err := base64.StdEncoding.DecodeString(img)
+		if err != nil {
+			return nil, &invalidRequestError{fmt.Sprintf("images.%d: image data is not valid base64", i)}
+		}
+		blocks = append(blocks, map[string]interface{}{"type": "image", "source": map[string]interface{}{
+			"type": "base64", "media_type": http.DetectContentType(data), "data": img,
+		}})
+	}
+	return blocks, nil
+}
+
+// toAnthropicRequest 把 /api/chat 请求转换为 AnthropicRequest。
+// Ollama 的工具调用没有 id，这里按顺序生成，tool 消息按 tool_name 或调用顺序对应
+func (req OllamaChatRequest) toAnthropicRequest() (AnthropicRequest, error) {
+	anthropicReq := AnthropicRequest{Model: ollamaModel(req.Model), Stream: req.Stream == nil || *req.Stream}
+	if err := req.Options.apply(&anthropicReq, req.Format, req.Think); err != nil {
+		return anthropicReq, err
+	}
+	for _, tool := range req.Tools {
+		anthropicReq.Tools = append(anthropicReq.Tools, openAIFunctionTool(tool.Function))
+	}
+
+	var callIDs toolCallIDs
+	var systemParts []string
+
+	for i, msg := range req.Messages {
+		switch msg.Role {
+		case "system":
+			systemParts = append(systemParts, msg.Content)
+
+		case "user":
+			blocks, err := ollamaImageBlocks(msg.Images)
+			if err != nil {
+				return anthropicReq, fmt.Errorf("messages.%d.%w", i, err)
+			}
+			blocks = append(blocks, map[string]interface{}{"type": "text", "text": msg.Content})
+			anthropicReq.Messages = append(anthropicReq.Messages, AnthropicRequestMessage{Role: "user", Content: blocks})
+
+		case "assistant":
+			var blocks []interface{}
+			if msg.Content != "" || len(msg.ToolCalls) == 0 {
+				blocks = append(blocks, map[string]interface{}{"type": "text", "text": msg.Content})
+			}
+			callIDs.reset()
+			for _, call := range msg.ToolCalls {
+				id := callIDs.add("", call.Function.Name)
+				input := call.Function.Arguments
+				if input == nil {
+					input = map[string]any{}
+				}
+				blocks = append(blocks, map[string]interface{}{"type": "tool_use", "id": id, "name": call.Function.Name, "input": input})
+			}
+			anthropicReq.Messages = append(anthropicReq.Messages, AnthropicRequestMessage{Role: "assistant", Content: blocks})
+
+		case "tool":
+			id, ok := callIDs.match("", msg.ToolName)
+			if !ok {
+				return anthropicReq, &invalidRequestError{fmt.Sprintf("messages.%d: tool message without a preceding tool call", i)}
+			}
+			anthropicReq.Messages = append(anthropicReq.Messages, AnthropicRequestMessage{Role: "user", Content: []interface{}{
+				map[string]interface{}{"type": "tool_result", "tool_use_id": id, "content": msg.Content},
+			}})
+
+		default:
+			return anthropicReq, &invalidRequestError{fmt.Sprintf("messages.%d.role: unexpected role %q", i, msg.Role)}
+		}
+	}
+	if len(systemParts) > 0 {
+		anthropicReq.System = append(AnthropicSystem{{Type: "text", Text: strings.Join(systemParts, "\n\n")}}, anthropicReq.System...)
+	}
+
+	return anthropicReq, nil
+}
+
+// toAnthropicRequest 把 /api/generate 请求转换为单轮 AnthropicRequest
+func (req OllamaGenerateRequest) toAnthropicRequest() (AnthropicRequest, error) {
+	anthropicReq := AnthropicRequest{Model: ollamaModel(req.Model), Stream: req.Stream == nil || *req.Stream}
+	if req.Suffix != "" {
+		return anthropicReq, &invalidRequestError{"suffix: fill-in-the-middle is not supported"}
+	}
+	if err := req.Options.apply(&anthropicReq, req.Format, req.Think); err != nil {
+		return anthropicReq, err
+	}
+	if req.System != "" {
+		anthropicReq.System = append(AnthropicSystem{{Type: "text", Text: req.System}}, anthropicReq.System...)
+	}
+
+	blocks, err := ollamaImageBlocks(req.Images)
+	if err != nil {
+		return anthropicReq, err
+	}
+	blocks = append(blocks, map[string]interface{}{"type": "text", "text": req.Prompt})
+	anthropicReq.Messages = []AnthropicRequestMessage{{Role: "user", Content: blocks}}
+	return anthropicReq, nil
+}
+
+// ollamaOutput 表示一段输出，由各端点转换为自己的响应字段
+type ollamaOutput struct {
+	Content   string
+	Thinking  string
+	ToolCalls []OllamaToolCall
+}
+
+// ollamaPayload 把一段输出转换为 /api/chat 或 /api/generate 的响应字段
+type ollamaPayload func(out ollamaOutput) map[string]any
+
+// ollamaChatPayload /api/chat 的输出放在 message 中
+func ollamaChatPayload(out ollamaOutput) map[string]any {
+	message := OllamaMessage{Role: "assistant", Content: out.Content, Thinking: out.Thinking, ToolCalls: out.ToolCalls}
+	return map[string]any{"message": message}
+}
+
+// ollamaGeneratePayload /api/generate 的输出放在 response 中
+func ollamaGeneratePayload(out ollamaOutput) map[string]any {
+	payload := map[string]any{"response": out.Content}
+	if out.Thinking != "" {
+		payload["thinking"] = out.Thinking
+	}
+	return payload
+}
+
+// ollamaToolCall 从 tool_use 的名称和参数构建 Ollama 工具调用
+func ollamaToolCall(name string, input any) OllamaToolCall {
+	var call OllamaToolCall
+	call.Function.Name = name
+	call.Function.Arguments, _ = input.(map[string]any)
+	return call
+}
+
+// ollamaDone 构建最后一条响应，包含结束原因和 token 统计
+func ollamaDone(model string, start time.Time, stopReason string, usage map[string]any, payload map[string]any) map[string]any {
+	doneReason := "stop"
+	if stopReason == "max_tokens" {
+		doneReason = "length"
+	}
+	elapsed := time.Since(start).Nanoseconds()
+	payload["model"] = model
+	payload["created_at"] = time.Now().UTC().Format(time.RFC3339Nano)
+	payload["done"] = true
+	payload["done_reason"] = doneReason
+	payload["total_duration"] = elapsed
+	payload["load_duration"] = 0
+	payload["prompt_eval_count"] = usage["input_tokens"]
+	payload["prompt_eval_duration"] = 0
+	payload["eval_count"] = usage["output_tokens"]
+	payload["eval_duration"] = elapsed
+	return payload
+}
+
+// handleOllamaChat 处理 POST /api/chat
+func handleOllamaChat(w http.ResponseWriter, r *http.Request) {
+	var req OllamaChatRequest
+	if !decodeOllamaRequest(w, r, &req) {
+		return
+	}
+	anthropicReq, err := req.toAnthropicRequest()
+	if err != nil {
+		writeOllamaError(w, upstreamErrorType(err), err.Error())
+		return
+	}
+	serveOllama(w, req.Model, anthropicReq, ollamaChatPayload)
+}
+
+// handleOllamaGenerate 处理 POST /api/generate
+func handleOllamaGenerate(w http.ResponseWriter, r *http.Request) {
+	var req OllamaGenerateRequest
+	if !decodeOllamaRequest(w, r, &req) {
+		return
+	}
+	anthropicReq, err := req.toAnthropicRequest()
+	if err != nil {
+		writeOllamaError(w, upstreamErrorType(err), err.Error())
+		return
+	}
+	// 空 prompt 在 Ollama 中只加载模型
+	if req.Prompt == "" && len(req.Images) == 0 {
+		anthropicReq.Messages = nil
+	}
+	serveOllama(w, req.Model, anthropicReq, ollamaGeneratePayload)
+}
+
+// decodeOllamaRequest 读取并解析请求体，失败时写出错误
+func decodeOllamaRequest(w http.ResponseWriter, r *http.Request, v any) bool {
+	if r.Method != http.MethodPost {
+		writeOllamaError(w, parser.InvalidRequestError, "只支持POST请求")
+		return false
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeOllamaError(w, parser.InvalidRequestError, fmt.Sprintf("读取请求体失败: %v", err))
+		return false
+	}
+	defer r.Body.Close()
+
+	if err := json.Unmarshal(body, v); err != nil {
+		writeOllamaError(w, parser.InvalidRequestError, fmt.Sprintf("解析请求体失败: %v", err))
+		return false
+	}
+	return true
+}
+
+// serveOllama 把请求发往上游并按 Ollama 格式返回，流式响应为 NDJSON。
+// 没有消息时与 Ollama 加载模型的行为一致，直接返回 done_reason 为 load 的响应
+func serveOllama(w http.ResponseWriter, model string, anthropicReq AnthropicRequest, payload ollamaPayload) {
+	if _, ok := ModelMap[anthropicReq.Model]; !ok {
+		writeOllamaError(w, parser.NotFoundError, fmt.Sprintf("model %q not found", model))
+		return
+	}
+
+	start := time.Now()
+	if len(anthropicReq.Messages) == 0 {
+		done := payload(ollamaOutput{})
+		done["model"] = model
+		done["created_at"] = start.UTC().Format(time.RFC3339Nano)
+		done["done"] = true
+		done["done_reason"] = "load"
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(done)
+		return
+	}
+
+	if anthropicReq.Stream {
+		streamOllamaResponse(w, model, start, anthropicReq, payload)
+		return
+	}
+
+	respBody, err := upstreamClient.Call(anthropicReq)
+	if err != nil {
+		writeOllamaError(w, upstreamErrorType(err), err.Error())
+		return
+	}
+	anthropicResp, err := buildAnthropicResponse(anthropicReq, respBody)
+	if err != nil {
+		writeOllamaError(w, upstreamErrorType(err), err.Error())
+		return
+	}
+
+	var out ollamaOutput
+	for _, block := range anthropicResp["content"].([]map[string]any) {
+		switch block["type"] {
+		case "text":
+			out.Content += block["text"].(string)
+		case "thinking":
+			out.Thinking += block["thinking"].(string)
+		case "tool_use":
+			out.ToolCalls = append(out.ToolCalls, ollamaToolCall(block["name"].(string), block["input"]))
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ollamaDone(model, start, anthropicResp["stop_reason"].(string), anthropicResp["usage"].(map[string]any), payload(out)))
+}
+
+// streamOllamaResponse 把翻译后的 Anthropic 事件转换为 NDJSON，每行一个响应对象。
+// 工具调用在参数完整后作为一整条输出
+func streamOllamaResponse(w http.ResponseWriter, model string, start time.Time, anthropicReq AnthropicRequest, payload ollamaPayload) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeOllamaError(w, parser.APIError, "Streaming unsupported!")
+		return
+	}
+
+	resp, err := upstreamClient.Stream(anthropicReq)
+	if err != nil {
+		writeOllamaError(w, upstreamErrorType(err), err.Error())
+		return
+	}
+	defer resp.Body.Close()
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	encoder := json.NewEncoder(w)
+	send := func(line map[string]any) {
+		encoder.Encode(line)
+		flusher.Flush()
+	}
+	sendOutput := func(out ollamaOutput) {
+		line := payload(out)
+		line["model"] = model
+		line["created_at"] = time.Now().UTC().Format(time.RFC3339Nano)
+		line["done"] = false
+		send(line)
+	}
+
+	type toolBlock struct {
+		name  string
+		input strings.Builder
+	}
+	tools := map[int]*toolBlock{}
+	translator := newTranslator(anthropicReq)
+	err = translator.Stream(resp.Body, func(e parser.SSEEvent) {
+		data, _ := e.Data.(map[string]interface{})
+		index, _ := data["index"].(int)
+		switch e.Event {
+		case "content_block_start":
+			block := data["content_block"].(map[string]interface{})
+			if block["type"] == "tool_use" {
+				tools[index] = &toolBlock{name: block["name"].(string)}
+			}
+		case "content_block_delta":
+			delta := data["delta"].(map[string]interface{})
+			switch delta["type"] {
+			case "text_delta":
+				sendOutput(ollamaOutput{Content: delta["text"].(string)})
+			case "thinking_delta":
+				sendOutput(ollamaOutput{Thinking: delta["thinking"].(string)})
+			case "input_json_delta":
+				if tool, ok := tools[index]; ok {
+					tool.input.WriteString(delta["partial_json"].(string))
+				}
+			}
+		case "content_block_stop":
+			tool, ok := tools[index]
+			if !ok {
+				return
+			}
+			delete(tools, index)
+			input := map[string]any{}
+			json.Unmarshal([]byte(tool.input.String()), &input)
+			sendOutput(ollamaOutput{ToolCalls: []OllamaToolCall{ollamaToolCall(tool.name, input)}})
+		}
+	})
+	if err == nil {
+		err = checkToolChoice(anthropicReq, translator.StopReason())
+	}
+	if err != nil {
+		fmt.Printf("错误: 读取 CodeWhisperer 响应流失败: %v\n", err)
+		send(map[string]any{"error": err.Error()})
+		return
+	}
+
+	usage := buildUsage(countInputTokens(anthropicReq), translator)
+	send(ollamaDone(model, start, translator.StopReason(), usage, payload(ollamaOutput{})))
+}
+
+// handleOllamaTags 处理 GET /api/tags，模型列表由 ModelMap 生成
+func handleOllamaTags(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeOllamaError(w, parser.InvalidRequestError, "只支持GET请求")
+		return
+	}
+
+	models := []map[string]any{}
+	for _, m := range listModels() {
+		models = append(models, map[string]any{
+			"name":        m.ID,
+			"model":       m.ID,
+			"modified_at": m.CreatedAt,
+			"size":        0,
+			"digest":      "",
+			"details": map[string]any{
+				"format":             "",
+				"family":             "claude",
+				"families":           []string{"claude"},
+				"parameter_size":     "",
+				"quantization_level": "",
+			},
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"models": models})
+}
+
+// writeOllamaError 以 Ollama 的 {"error": ...} 格式返回错误，状态码与 Anthropic 错误类型一致
+func writeOllamaError(w http.ResponseWriter, errorType, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(parser.StatusForErrorType(errorType))
+	json.NewEncoder(w).Encode(map[string]any{"error": message})
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestOllamaChatToAnthropicRequest(t *testing.T) {
+	body := `{
+		"model": "claude-sonnet-4-20250514:latest",
+		"format": "json",
+		"options": {"temperature": 1.5, "num_predict": 64, "stop": ["END"]},
+		"tools": [{"type": "function", "function": {"name": "get_weather", "parameters": {"type": "object"}}}],
+		"messages": [
+			{"role": "system", "content": "Be brief."},
+			{"role": "user", "content": "Weather in Paris and Rome?"},
+			{"role": "assistant", "content": "", "tool_calls": [
+				{"function": {"name": "get_weather", "arguments": {"city": "Paris"}}},
+				{"function": {"name": "get_time", "arguments": {"city": "Rome"}}}
+			]},
+			{"role": "tool", "tool_name": "get_time", "content": "noon"},
+			{"role": "tool", "content": "sunny"}
+		]
+	}`
+	var ollamaReq OllamaChatRequest
+	if err := json.Unmarshal([]byte(body), &ollamaReq); err != nil {
+		t.Fatal(err)
+	}
+	req, err := ollamaReq.toAnthropicRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if req.Model != "claude-sonnet-4-20250514" || !req.Stream {
+		t.Errorf("model=%q stream=%v", req.Model, req.Stream)
+	}
+	if req.MaxTokens != 64 || *req.Temperature != 1 || !reflect.DeepEqual(req.StopSequences, []string{"END"}) {
+		t.Errorf("options: max_tokens=%d temperature=%v stop=%v", req.MaxTokens, *req.Temperature, req.StopSequences)
+	}
+	if len(req.System) != 2 || req.System[0].Text != "Be brief." || !strings.Contains(req.System[1].Text, "JSON") {
+		t.Errorf("system = %+v", req.System)
+	}
+
+	want := []AnthropicRequestMessage{
+		{Role: "user", Content: []interface{}{
+			map[string]interface{}{"type": "text", "text": "Weather in Paris and Rome?"},
+		}},
+		{Role: "assistant", Content: []interface{}{
+			map[string]interface{}{"type": "tool_use", "id": "call_1", "name": "get_weather", "input": map[string]any{"city": "Paris"}},
+			map[string]interface{}{"type": "tool_use", "id": "call_2", "name": "get_time", "input": map[string]any{"city": "Rome"}},
+		}},
+		{Role: "user", Content: []interface{}{
+			map[string]interface{}{"type": "tool_result", "tool_use_id": "call_2", "content": "noon"},
+		}},
+		{Role: "user", Content: []interface{}{
+			map[string]interface{}{"type": "tool_result", "tool_use_id": "call_1", "content": "sunny"},
+		}},
+	}
+	if !reflect.DeepEqual(req.Messages, want) {
+		t.Errorf("messages:\ngot  %#v\nwant %#v", req.Messages, want)
+	}
+}
+
+func TestOllamaChat(t *testing.T) {
+	withMockUpstream(t)
+
+	body := `{"model":"claude-sonnet-4-20250514","stream":false,"messages":[{"role":"user","content":"hello"}]}`
+	rec := httptest.NewRecorder()
+	handleOllamaChat(rec, httptest.NewRequest(http.MethodPost, "/api/chat", strings.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body)
+	}
+
+	var resp struct {
+		Message    OllamaMessage `json:"message"`
+		Done       bool          `json:"done"`
+		DoneReason string        `json:"done_reason"`
+		EvalCount  int           `json:"eval_count"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Message.Role != "assistant" || resp.Message.Content != "Mock response to: hello" {
+		t.Errorf("message = %+v", resp.Message)
+	}
+	if !resp.Done || resp.DoneReason != "stop" || resp.EvalCount == 0 {
+		t.Errorf("done=%v done_reason=%q eval_count=%d", resp.Done, resp.DoneReason, resp.EvalCount)
+	}
+}
+
+func TestOllamaChatStream(t *testing.T) {
+	withMockUpstream(t)
+
+	body := `{"model":"claude-sonnet-4-20250514",
+		"tools":[{"type":"function","function":{"name":"Read"}}],
+		"messages":[{"role":"user","content":"[mock:tool] read it"}]}`
+	rec := httptest.NewRecorder()
+	handleOllamaChat(rec, httptest.NewRequest(http.MethodPost, "/api/chat", strings.NewReader(body)))
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/x-ndjson" {
+		t.Fatalf("status = %d, content type %q, body %s", rec.Code, rec.Header().Get("Content-Type"), rec.Body)
+	}
+
+	var lines []map[string]any
+	var toolCalls []OllamaToolCall
+	scanner := bufio.NewScanner(rec.Body)
+	for scanner.Scan() {
+		var line struct {
+			Message OllamaMessage `json:"message"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			t.Fatalf("bad line %q: %v", scanner.Text(), err)
+		}
+		toolCalls = append(toolCalls, line.Message.ToolCalls...)
+		var raw map[string]any
+		json.Unmarshal(scanner.Bytes(), &raw)
+		lines = append(lines, raw)
+	}
+
+	if len(toolCalls) != 1 || toolCalls[0].Function.Name != "Read" || toolCalls[0].Function.Arguments == nil {
+		t.Errorf("tool_calls = %+v", toolCalls)
+	}
+	for _, line := range lines[:len(lines)-1] {
+		if line["done"] != false {
+			t.Errorf("intermediate line is done: %v", line)
+		}
+	}
+	if last := lines[len(lines)-1]; last["done"] != true || last["done_reason"] != "stop" {
+		t.Errorf("last line = %v", last)
+	}
+}
+
+func TestOllamaGenerate(t *testing.T) {
+	withMockUpstream(t)
+
+	body := `{"model":"claude-sonnet-4-20250514","prompt":"hello","system":"Be brief.","stream":false}`
+	rec := httptest.NewRecorder()
+	handleOllamaGenerate(rec, httptest.NewRequest(http.MethodPost, "/api/generate", strings.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body)
+	}
+	var resp map[string]any
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	// 模拟上游回显的内容包含合并进来的 system
+	if text, _ := resp["response"].(string); !strings.HasSuffix(text, "hello") || resp["done"] != true {
+		t.Errorf("response = %v", resp)
+	}
+
+	// 空 prompt 只加载模型
+	rec = httptest.NewRecorder()
+	handleOllamaGenerate(rec, httptest.NewRequest(http.MethodPost, "/api/generate", strings.NewReader(`{"model":"claude-sonnet-4-20250514"}`)))
+	if !strings.Contains(rec.Body.String(), `"done_reason":"load"`) {
+		t.Errorf("empty prompt: body %s", rec.Body)
+	}
+
+	rec = httptest.NewRecorder()
+	handleOllamaGenerate(rec, httptest.NewRequest(http.MethodPost, "/api/generate", strings.NewReader(`{"model":"llama3","prompt":"hi"}`)))
+	if rec.Code != http.StatusNotFound || !strings.Contains(rec.Body.String(), `"error"`) {
+		t.Errorf("unknown model: status = %d, body %s", rec.Code, rec.Body)
+	}
+}
+
+func TestOllamaTags(t *testing.T) {
+	rec := httptest.NewRecorder()
+	handleOllamaTags(rec, httptest.NewRequest(http.MethodGet, "/api/tags", nil))
+
+	var resp struct {
+		Models []struct {
+			Name string `json:"name"`
+		} `json:"models"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Models) != len(ModelMap) {
+		t.Fatalf("got %d models, want %d", len(resp.Models), len(ModelMap))
+	}
+	for _, m := range resp.Models {
+		if _, ok := ModelMap[m.Name]; !ok {
+			t.Errorf("unknown model %q", m.Name)
+		}
+	}
+}
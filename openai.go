@@ -0,0 +1,535 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bestk/kiro2cc/parser"
+)
+
+// OpenAIChatRequest 表示 OpenAI /v1/chat/completions 的请求结构
+type OpenAIChatRequest struct {
+	Model               string              `json:"model"`
+	Messages            []OpenAIChatMessage `json:"messages"`
+	Tools               []OpenAITool        `json:"tools,omitempty"`
+	ToolChoice          any                 `json:"tool_choice,omitempty"` // "none"、"auto"、"required" 或 {"type":"function","function":{"name":...}}
+	ParallelToolCalls   *bool               `json:"parallel_tool_calls,omitempty"`
+	Functions           []OpenAIFunction    `json:"functions,omitempty"`     // 旧版函数调用
+	FunctionCall        any                 `json:"function_call,omitempty"` // 旧版函数调用，"none"、"auto" 或 {"name":...}
+	Stream              bool                `json:"stream"`
+	StreamOptions       *OpenAIStreamOpts   `json:"stream_options,omitempty"`
+	MaxTokens           *int                `json:"max_tokens,omitempty"`
+	MaxCompletionTokens *int                `json:"max_completion_tokens,omitempty"`
+	Temperature         *float64            `json:"temperature,omitempty"`
+	TopP                *float64            `json:"top_p,omitempty"`
+	Stop                any                 `json:"stop,omitempty"` // string 或 []string
+	N                   *int                `json:"n,omitempty"`
+	User                string              `json:"user,omitempty"`
+}
+
+// OpenAIStreamOpts 表示 stream_options
+type OpenAIStreamOpts struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
+// OpenAIChatMessage 表示 OpenAI 的一条消息
+type OpenAIChatMessage struct {
+	Role         string              `json:"role"`
+	Content      any                 `json:"content"` // string、内容片段数组或 null
+	Name         string              `json:"name,omitempty"`
+	ToolCalls    []OpenAIToolCall    `json:"tool_calls,omitempty"`
+	ToolCallId   string              `json:"tool_call_id,omitempty"`
+	FunctionCall *OpenAIFunctionCall `json:"function_call,omitempty"`
+}
+
+// OpenAIToolCall 表示助手消息中的一次工具调用，流式响应中带 index
+type OpenAIToolCall struct {
+	Index    *int               `json:"index,omitempty"`
+	Id       string             `json:"id,omitempty"`
+	Type     string             `json:"type,omitempty"`
+	Function OpenAIFunctionCall `json:"function"`
+}
+
+// OpenAIFunctionCall 表示被调用的函数及其 JSON 参数
+type OpenAIFunctionCall struct {
+	Name      string `json:"name,omitempty"`
+	Arguments string `json:"arguments"`
+}
+
+// OpenAITool 表示 tools 中的一个函数工具
+type OpenAITool struct {
+	Type     string         `json:"type"`
+	Function OpenAIFunction `json:"function"`
+}
+
+// OpenAIFunction 表示函数定义
+type OpenAIFunction struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description,omitempty"`
+	Parameters  map[string]any `json:"parameters,omitempty"`
+}
+
+// openAIFinishReasons Anthropic stop_reason 到 OpenAI finish_reason 的映射
+var openAIFinishReasons = map[string]string{
+	"end_turn":      "stop",
+	"stop_sequence": "stop",
+	"max_tokens":    "length",
+	"tool_use":      "tool_calls",
+}
+
+// toAnthropicRequest 把 OpenAI 请求转换为 AnthropicRequest，之后走与 /v1/messages 相同的流程
+func (req OpenAIChatRequest) toAnthropicRequest() (AnthropicRequest, error) {
+	anthropicReq := AnthropicRequest{
+		Model:  req.Model,
+		Stream: req.Stream,
+		TopP:   req.TopP,
+	}
+
+	if req.N != nil && *req.N != 1 {
+		return anthropicReq, &invalidRequestError{"n: only 1 choice is supported"}
+	}
+	if req.MaxCompletionTokens != nil {
+		anthropicReq.MaxTokens = *req.MaxCompletionTokens
+	} else if req.MaxTokens != nil {
+		anthropicReq.MaxTokens = *req.MaxTokens
+	}
+	if req.Temperature != nil {
+		// OpenAI 的取值范围是 0 到 2，Anthropic 是 0 到 1
+		temperature := *req.Temperature / 2
+		anthropicReq.Temperature = &temperature
+	}
+	switch stop := req.Stop.(type) {
+	case string:
+		anthropicReq.StopSequences = []string{stop}
+	case []interface{}:
+		for _, s := range stop {
+			if s, ok := s.(string); ok {
+				anthropicReq.StopSequences = append(anthropicReq.StopSequences, s)
+			}
+		}
+	}
+
+	for _, tool := range req.Tools {
+		anthropicReq.Tools = append(anthropicReq.Tools, openAIFunctionTool(tool.Function))
+	}
+	for _, fn := range req.Functions {
+		anthropicReq.Tools = append(anthropicReq.Tools, openAIFunctionTool(fn))
+	}
+
+	toolChoice, err := openAIToolChoice(req.ToolChoice, req.FunctionCall)
+	if err != nil {
+		return anthropicReq, err
+	}
+	if req.ParallelToolCalls != nil && !*req.ParallelToolCalls {
+		if toolChoice == nil {
+			toolChoice = &AnthropicToolChoice{Type: ToolChoiceAuto}
+		}
+		disable := true
+		toolChoice.DisableParallelToolUse = &disable
+	}
+	anthropicReq.ToolChoice = toolChoice
+
+	var systemParts []string
+	var pendingFunctionCall string // 旧版 function 消息没有 id，对应最近一次 function_call
+	for i, msg := range req.Messages {
+		switch msg.Role {
+		case "system", "developer":
+			systemParts = append(systemParts, openAIContentText(msg.Content))
+
+		case "user":
+			blocks, err := openAIContentBlocks(msg.Content)
+			if err != nil {
+				return anthropicReq, fmt.Errorf("messages.%d.%w", i, err)
+			}
+			anthropicReq.Messages = append(anthropicReq.Messages, AnthropicRequestMessage{Role: "user", Content: blocks})
+
+		case "assistant":
+			var blocks []interface{}
+			if text := openAIContentText(msg.Content); text != "" {
+				blocks = append(blocks, map[string]interface{}{"type": "text", "text": text})
+			}
+			calls := msg.ToolCalls
+			if msg.FunctionCall != nil {
+				pendingFunctionCall = fmt.Sprintf("call_%d", i)
+				calls = append(calls, OpenAIToolCall{Id: pendingFunctionCall, Function: *msg.FunctionCall})
+			}
+			for j, call := range calls {
+				input := map[string]interface{}{}
+				if strings.TrimSpace(call.Function.Arguments) != "" {
+					if err := json.Unmarshal([]byte(call.Function.Arguments), &input); err != nil {
+						return anthropicReq, &invalidRequestError{fmt.Sprintf("messages.%d.tool_calls.%d.function.arguments: must be a JSON object", i, j)}
+					}
+				}
+				blocks = append(blocks, map[string]interface{}{
+					"type":  "tool_use",
+					"id":    call.Id,
+					"name":  call.Function.Name,
+					"input": input,
+				})
+			}
+			if len(blocks) == 0 {
+				blocks = append(blocks, map[string]interface{}{"type": "text", "text": ""})
+			}
+			anthropicReq.Messages = append(anthropicReq.Messages, AnthropicRequestMessage{Role: "assistant", Content: blocks})
+
+		case "tool", "function":
+			toolUseId := msg.ToolCallId
+			if msg.Role == "function" {
+				toolUseId = pendingFunctionCall
+			}
+			anthropicReq.Messages = append(anthropicReq.Messages, AnthropicRequestMessage{Role: "user", Content: []interface{}{
+				map[string]interface{}{
+					"type":        "tool_result",
+					"tool_use_id": toolUseId,
+					"content":     openAIContentText(msg.Content),
+				},
+			}})
+
+		default:
+			return anthropicReq, &invalidRequestError{fmt.Sprintf("messages.%d.role: unexpected role %q", i, msg.Role)}
+		}
+	}
+	if text := strings.Join(systemParts, "\n\n"); text != "" {
+		anthropicReq.System = AnthropicSystem{{Type: "text", Text: text}}
+	}
+
+	return anthropicReq, nil
+}
+
+// openAIFunctionTool 把 OpenAI 函数定义转换为 Anthropic 工具
+func openAIFunctionTool(fn OpenAIFunction) AnthropicTool {
+	schema := fn.Parameters
+	if schema == nil {
+		schema = map[string]any{"type": "object", "properties": map[string]any{}}
+	}
+	return AnthropicTool{Name: fn.Name, Description: fn.Description, InputSchema: schema}
+}
+
+// openAIToolChoice 把 tool_choice（或旧版 function_call）转换为 Anthropic 的 tool_choice
+func openAIToolChoice(toolChoice, functionCall any) (*AnthropicToolChoice, error) {
+	choice := toolChoice
+	if choice == nil {
+		choice = functionCall
+	}
+
+	switch v := choice.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		switch v {
+		case "auto":
+			return &AnthropicToolChoice{Type: ToolChoiceAuto}, nil
+		case "none":
+			return &AnthropicToolChoice{Type: ToolChoiceNone}, nil
+		case "required":
+			return &AnthropicToolChoice{Type: ToolChoiceAny}, nil
+		}
+	case map[string]interface{}:
+		// {"type":"function","function":{"name":...}}，旧版为 {"name":...}
+		if fn, ok := v["function"].(map[string]interface{}); ok {
+			v = fn
+		}
+		if name, ok := v["name"].(string); ok && name != "" {
+			return &AnthropicToolChoice{Type: ToolChoiceTool, Name: name}, nil
+		}
+	}
+	return nil, &invalidRequestError{fmt.Sprintf("tool_choice: unsupported value %v", choice)}
+}
+
+// openAIContentText 提取 OpenAI 消息内容中的文本，content 可以是 string、片段数组或 null。
+// 片段类型兼容 Chat Completions 的 text 和 Responses API 的 input_text、output_text
+func openAIContentText(content any) string {
+	switch v := content.(type) {
+	case string:
+		return v
+	case []interface{}:
+		var texts []string
+		for _, part := range v {
+			if m, ok := part.(map[string]interface{}); ok && (m["type"] == "text" || m["type"] == "input_text" || m["type"] == "output_text") {
+				if text, ok := m["text"].(string); ok {
+					texts = append(texts, text)
+				}
+			}
+		}
+		return strings.Join(texts, "\n")
+	}
+	return ""
+}
+
+// openAIContentBlocks 把 user 消息内容转换为 Anthropic 内容块，image_url 转为 image 块
+func openAIContentBlocks(content any) ([]interface{}, error) {
+	parts, ok := content.([]interface{})
+	if !ok {
+		return []interface{}{map[string]interface{}{"type": "text", "text": openAIContentText(content)}}, nil
+	}
+
+	var blocks []interface{}
+	for i, part := range parts {
+		m, ok := part.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		switch m["type"] {
+		case "text", "input_text":
+			blocks = append(blocks, map[string]interface{}{"type": "text", "text": m["text"]})
+		case "image_url", "input_image":
+			// image_url 通常是 {"url": ...}，Responses API 的 input_image 和部分客户端直接传字符串
+			url, _ := m["image_url"].(string)
+			if obj, ok := m["image_url"].(map[string]interface{}); ok {
+				url, _ = obj["url"].(string)
+			}
+			source, err := imageURLSource(url)
+			if err != nil {
+				return nil, fmt.Errorf("content.%d.%w", i, err)
+			}
+			blocks = append(blocks, map[string]interface{}{"type": "image", "source": source})
+		default:
+			return nil, &invalidRequestError{fmt.Sprintf("content.%d.type: unsupported content part %v", i, m["type"])}
+		}
+	}
+	return blocks, nil
+}
+
+// imageURLSource 把 image_url 转换为 Anthropic 的图片来源，data URL 转为 base64，其余按 url 处理
+func imageURLSource(url string) (map[string]interface{}, error) {
+	if !strings.HasPrefix(url, "data:") {
+		if url == "" {
+			return nil, &invalidRequestError{"image_url.url: required"}
+		}
+		return map[string]interface{}{"type": "url", "url": url}, nil
+	}
+
+	header, data, ok := strings.Cut(strings.TrimPrefix(url, "data:"), ",")
+	mediaType, encoding, _ := strings.Cut(header, ";")
+	if !ok || encoding != "base64" {
+		return nil, &invalidRequestError{"image_url.url: data URLs must be base64 encoded"}
+	}
+	return map[string]interface{}{"type": "base64", "media_type": mediaType, "data": data}, nil
+}
+
+// openAIUsage 把 Anthropic usage 转换为 OpenAI usage
+func openAIUsage(usage map[string]any) map[string]any {
+	prompt, _ := usage["input_tokens"].(int)
+	completion, _ := usage["output_tokens"].(int)
+	return map[string]any{
+		"prompt_tokens":     prompt,
+		"completion_tokens": completion,
+		"total_tokens":      prompt + completion,
+	}
+}
+
+// buildOpenAIResponse 把 Anthropic 响应转换为 chat.completion 对象
+func buildOpenAIResponse(id string, anthropicResp map[string]any) map[string]any {
+	var texts []string
+	var toolCalls []OpenAIToolCall
+	for _, block := range anthropicResp["content"].([]map[string]any) {
+		switch block["type"] {
+		case "text":
+			texts = append(texts, block["text"].(string))
+		case "tool_use":
+			arguments, _ := json.Marshal(block["input"])
+			toolCalls = append(toolCalls, OpenAIToolCall{
+				Id:       block["id"].(string),
+				Type:     "function",
+				Function: OpenAIFunctionCall{Name: block["name"].(string), Arguments: string(arguments)},
+			})
+		}
+	}
+
+	message := map[string]any{"role": "assistant", "content": nil}
+	if len(texts) > 0 {
+		message["content"] = strings.Join(texts, "")
+	}
+	if len(toolCalls) > 0 {
+		message["tool_calls"] = toolCalls
+	}
+
+	return map[string]any{
+		"id":      id,
+		"object":  "chat.completion",
+		"created": time.Now().Unix(),
+		"model":   anthropicResp["model"],
+		"choices": []map[string]any{{
+			"index":         0,
+			"message":       message,
+			"finish_reason": openAIFinishReasons[anthropicResp["stop_reason"].(string)],
+		}},
+		"usage": openAIUsage(anthropicResp["usage"].(map[string]any)),
+	}
+}
+
+// handleOpenAIChatCompletions 处理 POST /v1/chat/completions
+func handleOpenAIChatCompletions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeOpenAIError(w, parser.InvalidRequestError, "只支持POST请求")
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeOpenAIError(w, parser.InvalidRequestError, fmt.Sprintf("读取请求体失败: %v", err))
+		return
+	}
+	defer r.Body.Close()
+
+	var openAIReq OpenAIChatRequest
+	if err := json.Unmarshal(body, &openAIReq); err != nil {
+		writeOpenAIError(w, parser.InvalidRequestError, fmt.Sprintf("解析请求体失败: %v", err))
+		return
+	}
+	anthropicReq, err := openAIReq.toAnthropicRequest()
+	if err != nil {
+		writeOpenAIError(w, upstreamErrorType(err), err.Error())
+		return
+	}
+	if _, ok := ModelMap[anthropicReq.Model]; !ok {
+		writeOpenAIError(w, parser.NotFoundError, fmt.Sprintf("model: unknown or unsupported model %q", anthropicReq.Model))
+		return
+	}
+
+	id := "chatcmpl-" + strings.ReplaceAll(generateUUID(), "-", "")
+	if openAIReq.Stream {
+		streamOpenAIResponse(w, id, anthropicReq, openAIReq.StreamOptions != nil && openAIReq.StreamOptions.IncludeUsage)
+		return
+	}
+
+	respBody, err := upstreamClient.Call(anthropicReq)
+	if err != nil {
+		writeOpenAIError(w, upstreamErrorType(err), err.Error())
+		return
+	}
+	anthropicResp, err := buildAnthropicResponse(anthropicReq, respBody)
+	if err != nil {
+		writeOpenAIError(w, upstreamErrorType(err), err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(buildOpenAIResponse(id, anthropicResp))
+}
+
+// streamOpenAIResponse 把翻译后的 Anthropic 事件转换为 chat.completion.chunk，以 data: [DONE] 结束
+func streamOpenAIResponse(w http.ResponseWriter, id string, anthropicReq AnthropicRequest, includeUsage bool) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeOpenAIError(w, parser.APIError, "Streaming unsupported!")
+		return
+	}
+
+	resp, err := upstreamClient.Stream(anthropicReq)
+	if err != nil {
+		writeOpenAIError(w, upstreamErrorType(err), err.Error())
+		return
+	}
+	defer resp.Body.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	created := time.Now().Unix()
+	sendChunk := func(delta map[string]any, finishReason any) {
+		sendOpenAIData(w, flusher, map[string]any{
+			"id":      id,
+			"object":  "chat.completion.chunk",
+			"created": created,
+			"model":   anthropicReq.Model,
+			"choices": []map[string]any{{"index": 0, "delta": delta, "finish_reason": finishReason}},
+		})
+	}
+	sendChunk(map[string]any{"role": "assistant", "content": ""}, nil)
+
+	// Anthropic 的 block index 包含文本块，OpenAI 的 tool_calls index 只计工具调用
+	toolIndexes := map[int]int{}
+	translator := newTranslator(anthropicReq)
+	err = translator.Stream(resp.Body, func(e parser.SSEEvent) {
+		data, _ := e.Data.(map[string]interface{})
+		index, _ := data["index"].(int)
+		switch e.Event {
+		case "content_block_start":
+			block := data["content_block"].(map[string]interface{})
+			if block["type"] != "tool_use" {
+				return
+			}
+			toolIndex := len(toolIndexes)
+			toolIndexes[index] = toolIndex
+			sendChunk(map[string]any{"tool_calls": []OpenAIToolCall{{
+				Index:    &toolIndex,
+				Id:       block["id"].(string),
+				Type:     "function",
+				Function: OpenAIFunctionCall{Name: block["name"].(string)},
+			}}}, nil)
+		case "content_block_delta":
+			delta := data["delta"].(map[string]interface{})
+			switch delta["type"] {
+			case "text_delta":
+				sendChunk(map[string]any{"content": delta["text"]}, nil)
+			case "input_json_delta":
+				toolIndex := toolIndexes[index]
+				sendChunk(map[string]any{"tool_calls": []OpenAIToolCall{{
+					Index:    &toolIndex,
+					Function: OpenAIFunctionCall{Arguments: delta["partial_json"].(string)},
+				}}}, nil)
+			}
+		}
+	})
+	if err == nil {
+		err = checkToolChoice(anthropicReq, translator.StopReason())
+	}
+	if err != nil {
+		fmt.Printf("错误: 读取 CodeWhisperer 响应流失败: %v\n", err)
+		sendOpenAIData(w, flusher, openAIError(upstreamErrorType(err), err.Error()))
+		return
+	}
+
+	sendChunk(map[string]any{}, openAIFinishReasons[translator.StopReason()])
+	if includeUsage {
+		sendOpenAIData(w, flusher, map[string]any{
+			"id":      id,
+			"object":  "chat.completion.chunk",
+			"created": created,
+			"model":   anthropicReq.Model,
+			"choices": []map[string]any{},
+			"usage":   openAIUsage(buildUsage(countInputTokens(anthropicReq), translator)),
+		})
+	}
+	fmt.Fprint(w, "data: [DONE]\n\n")
+	flusher.Flush()
+}
+
+// sendOpenAIData 以 OpenAI 的格式发送一个 data: 事件
+func sendOpenAIData(w http.ResponseWriter, flusher http.Flusher, data any) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(w, "data: %s\n\n", payload)
+	flusher.Flush()
+}
+
+// openAIError 构建 OpenAI 格式的错误对象，api_error 和 overloaded_error 对应 server_error
+func openAIError(errorType, message string) map[string]any {
+	if errorType == parser.APIError || errorType == parser.OverloadedError {
+		errorType = "server_error"
+	}
+	return map[string]any{
+		"error": map[string]any{
+			"message": message,
+			"type":    errorType,
+			"param":   nil,
+			"code":    nil,
+		},
+	}
+}
+
+// writeOpenAIError 以 OpenAI 错误格式返回 JSON 错误响应，状态码与 Anthropic 错误类型一致
+func writeOpenAIError(w http.ResponseWriter, errorType, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(parser.StatusForErrorType(errorType))
+	json.NewEncoder(w).Encode(openAIError(errorType, message))
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bestk/kiro2cc/mockupstream"
+)
+
+// withMockUpstream 让 upstreamClient 在测试期间指向模拟上游
+func withMockUpstream(t *testing.T) *mockupstream.Server {
+	t.Helper()
+	ts, mock := mockupstream.NewTestServer()
+	saved := config.MockUpstream
+	config.MockUpstream.Enabled = true
+	config.MockUpstream.URL = ts.URL + "/generateAssistantResponse"
+	t.Cleanup(func() {
+		config.MockUpstream = saved
+		ts.Close()
+	})
+	return mock
+}
+
+func TestOpenAIToAnthropicRequest(t *testing.T) {
+	body := `{
+		"model": "claude-sonnet-4-20250514",
+		"max_tokens": 256,
+		"temperature": 1.4,
+		"stop": "END",
+		"parallel_tool_calls": false,
+		"tools": [{"type": "function", "function": {"name": "get_weather", "parameters": {"type": "object"}}}],
+		"messages": [
+			{"role": "system", "content": "Be brief."},
+			{"role": "user", "content": [
+				{"type": "text", "text": "Weather here?"},
+				{"type": "image_url", "image_url": {"url": "data:image/png;base64,iVBORw0KGgo="}}
+			]},
+			{"role": "assistant", "content": null, "tool_calls": [
+				{"id": "call_1", "type": "function", "function": {"name": "get_weather", "arguments": "{\"city\":\"Paris\"}"}}
+			]},
+			{"role": "tool", "tool_call_id": "call_1", "content": "sunny"}
+		]
+	}`
+	var openAIReq OpenAIChatRequest
+	if err := json.Unmarshal([]byte(body), &openAIReq); err != nil {
+		t.Fatal(err)
+	}
+	req, err := openAIReq.toAnthropicRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if req.MaxTokens != 256 || *req.Temperature != 0.7 || !reflect.DeepEqual(req.StopSequences, []string{"END"}) {
+		t.Errorf("parameters: max_tokens=%d temperature=%v stop=%v", req.MaxTokens, *req.Temperature, req.StopSequences)
+	}
+	if req.System.Text() != "Be brief." {
+		t.Errorf("system = %q", req.System.Text())
+	}
+	if len(req.Tools) != 1 || req.Tools[0].Name != "get_weather" {
+		t.Errorf("tools = %+v", req.Tools)
+	}
+	if req.ToolChoice == nil || req.ToolChoice.Type != ToolChoiceAuto || !*req.ToolChoice.DisableParallelToolUse {
+		t.Errorf("tool_choice = %+v, want auto with parallel tool use disabled", req.ToolChoice)
+	}
+
+	want := []AnthropicRequestMessage{
+		{Role: "user", Content: []interface{}{
+			map[string]interface{}{"type": "text", "text": "Weather here?"},
+			map[string]interface{}{"type": "image", "source": map[string]interface{}{"type": "base64", "media_type": "image/png", "data": "iVBORw0KGgo="}},
+		}},
+		{Role: "assistant", Content: []interface{}{
+			map[string]interface{}{"type": "tool_use", "id": "call_1", "name": "get_weather", "input": map[string]interface{}{"city": "Paris"}},
+		}},
+		{Role: "user", Content: []interface{}{
+			map[string]interface{}{"type": "tool_result", "tool_use_id": "call_1", "content": "sunny"},
+		}},
+	}
+	if !reflect.DeepEqual(req.Messages, want) {
+		t.Errorf("messages:\ngot  %#v\nwant %#v", req.Messages, want)
+	}
+}
+
+func TestOpenAIToolChoice(t *testing.T) {
+	tests := []struct {
+		toolChoice, functionCall any
+		want                     *AnthropicToolChoice
+	}{
+		{nil, nil, nil},
+		{"auto", nil, &AnthropicToolChoice{Type: ToolChoiceAuto}},
+		{"none", nil, &AnthropicToolChoice{Type: ToolChoiceNone}},
+		{"required", nil, &AnthropicToolChoice{Type: ToolChoiceAny}},
+		{map[string]interface{}{"type": "function", "function": map[string]interface{}{"name": "f"}}, nil, &AnthropicToolChoice{Type: ToolChoiceTool, Name: "f"}},
+		{nil, map[string]interface{}{"name": "legacy"}, &AnthropicToolChoice{Type: ToolChoiceTool, Name: "legacy"}},
+	}
+
+	for _, tt := range tests {
+		got, err := openAIToolChoice(tt.toolChoice, tt.functionCall)
+		if err != nil {
+			t.Errorf("%v/%v: %v", tt.toolChoice, tt.functionCall, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%v/%v: got %+v, want %+v", tt.toolChoice, tt.functionCall, got, tt.want)
+		}
+	}
+
+	if _, err := openAIToolChoice("sometimes", nil); err == nil {
+		t.Error("unknown tool_choice: expected an error")
+	}
+}
+
+func TestOpenAIChatCompletions(t *testing.T) {
+	withMockUpstream(t)
+
+	body := `{"model":"claude-sonnet-4-20250514","messages":[{"role":"user","content":"hello"}]}`
+	rec := httptest.NewRecorder()
+	handleOpenAIChatCompletions(rec, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body)
+	}
+
+	var resp struct {
+		Object  string `json:"object"`
+		Choices []struct {
+			Message      map[string]any `json:"message"`
+			FinishReason string         `json:"finish_reason"`
+		} `json:"choices"`
+		Usage map[string]int `json:"usage"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Object != "chat.completion" || len(resp.Choices) != 1 {
+		t.Fatalf("unexpected response: %s", rec.Body)
+	}
+	if got := resp.Choices[0].Message["content"]; got != "Mock response to: hello" {
+		t.Errorf("content = %v", got)
+	}
+	if resp.Choices[0].FinishReason != "stop" {
+		t.Errorf("finish_reason = %s, want stop", resp.Choices[0].FinishReason)
+	}
+	if resp.Usage["total_tokens"] != resp.Usage["prompt_tokens"]+resp.Usage["completion_tokens"] || resp.Usage["completion_tokens"] == 0 {
+		t.Errorf("usage = %v", resp.Usage)
+	}
+}
+
+func TestOpenAIChatCompletionsStream(t *testing.T) {
+	withMockUpstream(t)
+
+	body := `{"model":"claude-sonnet-4-20250514","stream":true,"stream_options":{"include_usage":true},
+		"tools":[{"type":"function","function":{"name":"Read"}}],
+		"messages":[{"role":"user","content":"[mock:tool] read it"}]}`
+	rec := httptest.NewRecorder()
+	handleOpenAIChatCompletions(rec, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body)
+	}
+
+	var chunks []map[string]any
+	done := false
+	scanner := bufio.NewScanner(rec.Body)
+	for scanner.Scan() {
+		line, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		if line == "[DONE]" {
+			done = true
+			continue
+		}
+		var chunk map[string]any
+		if err := json.Unmarshal([]byte(line), &chunk); err != nil {
+			t.Fatalf("bad chunk %q: %v", line, err)
+		}
+		chunks = append(chunks, chunk)
+	}
+	if !done {
+		t.Error("stream did not end with data: [DONE]")
+	}
+
+	var arguments, finishReason string
+	var toolName any
+	for _, chunk := range chunks {
+		choices := chunk["choices"].([]any)
+		if len(choices) == 0 {
+			continue
+		}
+		choice := choices[0].(map[string]any)
+		if fr, ok := choice["finish_reason"].(string); ok {
+			finishReason = fr
+		}
+		delta := choice["delta"].(map[string]any)
+		if calls, ok := delta["tool_calls"].([]any); ok {
+			call := calls[0].(map[string]any)
+			fn := call["function"].(map[string]any)
+			if name, ok := fn["name"]; ok {
+				toolName = name
+			}
+			arguments += fn["arguments"].(string)
+		}
+	}
+	if toolName != "Read" || !json.Valid([]byte(arguments)) {
+		t.Errorf("tool call %v with arguments %q", toolName, arguments)
+	}
+	if finishReason != "tool_calls" {
+		t.Errorf("finish_reason = %q, want tool_calls", finishReason)
+	}
+	if last := chunks[len(chunks)-1]; last["usage"] == nil {
+		t.Errorf("last chunk has no usage: %v", last)
+	}
+}
+
+func TestOpenAIErrors(t *testing.T) {
+	withMockUpstream(t)
+
+	tests := []struct {
+		body   string
+		status int
+	}{
+		{`{"model":`, http.StatusBadRequest},
+		{`{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}]}`, http.StatusNotFound},
+		{`{"model":"claude-sonnet-4-20250514","n":2,"messages":[{"role":"user","content":"hi"}]}`, http.StatusBadRequest},
+		{`{"model":"claude-sonnet-4-20250514","messages":[{"role":"user","content":"[mock:throttle] hi"}]}`, http.StatusTooManyRequests},
+	}
+
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		handleOpenAIChatCompletions(rec, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(tt.body)))
+		if rec.Code != tt.status {
+			t.Errorf("%s: status = %d, want %d", tt.body, rec.Code, tt.status)
+		}
+		var resp struct {
+			Error struct {
+				Message string `json:"message"`
+				Type    string `json:"type"`
+			} `json:"error"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || resp.Error.Message == "" {
+			t.Errorf("%s: body %s is not an OpenAI error", tt.body, rec.Body)
+		}
+	}
+}
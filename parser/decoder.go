@@ -0,0 +1,114 @@
+package parser
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+const (
+	preludeLen = 12 // total length + header length + prelude CRC
+	crcLen     = 4  // trailing message CRC
+
+	// DefaultMaxFrameSize bounds the memory a single frame may claim. The
+	// AWS event-stream spec caps messages at 16 MiB.
+	DefaultMaxFrameSize = 16 << 20
+)
+
+var (
+	// ErrTruncatedFrame is returned when the stream ends in the middle of a frame.
+	ErrTruncatedFrame = errors.New("event stream: truncated frame")
+	// ErrInvalidFrameLength is returned when the prelude lengths are inconsistent.
+	ErrInvalidFrameLength = errors.New("event stream: invalid frame length")
+	// ErrFrameTooLarge is returned when a frame exceeds Decoder.MaxFrameSize.
+	ErrFrameTooLarge = errors.New("event stream: frame too large")
+	// ErrPreludeChecksum is returned when the prelude CRC does not match.
+	ErrPreludeChecksum = errors.New("event stream: prelude checksum mismatch")
+	// ErrMessageChecksum is returned when the message CRC does not match.
+	ErrMessageChecksum = errors.New("event stream: message checksum mismatch")
+	// ErrInvalidHeader is returned when the header section cannot be decoded.
+	ErrInvalidHeader = errors.New("event stream: invalid header")
+)
+
+// Frame is a single AWS event-stream message.
+type Frame struct {
+	Headers Headers
+	Payload []byte
+}
+
+// Decoder reads event-stream frames one at a time from an io.Reader.
+type Decoder struct {
+	// MaxFrameSize is the largest total frame length accepted, in bytes.
+	// Lengths come straight off the wire, so this is checked before any
+	// buffer is allocated.
+	MaxFrameSize uint32
+
+	r       io.Reader
+	prelude [preludeLen]byte
+}
+
+// NewDecoder returns a Decoder that reads frames from r, accepting frames
+// up to DefaultMaxFrameSize.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: r, MaxFrameSize: DefaultMaxFrameSize}
+}
+
+// Next reads the next frame. It returns io.EOF when the stream ends cleanly
+// on a frame boundary and ErrTruncatedFrame when it ends inside a frame.
+// Checksum and header problems are reported with the Err* values above,
+// wrapped with details; test them with errors.Is.
+func (d *Decoder) Next() (Frame, error) {
+	if _, err := io.ReadFull(d.r, d.prelude[:]); err != nil {
+		if err == io.EOF {
+			return Frame{}, io.EOF
+		}
+		return Frame{}, truncated(err)
+	}
+
+	totalLen := binary.BigEndian.Uint32(d.prelude[0:4])
+	headerLen := binary.BigEndian.Uint32(d.prelude[4:8])
+	preludeCRC := binary.BigEndian.Uint32(d.prelude[8:12])
+
+	if sum := crc32.ChecksumIEEE(d.prelude[0:8]); sum != preludeCRC {
+		return Frame{}, fmt.Errorf("%w: expected %08x, got %08x", ErrPreludeChecksum, preludeCRC, sum)
+	}
+
+	if totalLen < preludeLen+crcLen || headerLen > totalLen-preludeLen-crcLen {
+		return Frame{}, fmt.Errorf("%w: total=%d header=%d", ErrInvalidFrameLength, totalLen, headerLen)
+	}
+	if d.MaxFrameSize > 0 && totalLen > d.MaxFrameSize {
+		return Frame{}, fmt.Errorf("%w: %d bytes, limit %d", ErrFrameTooLarge, totalLen, d.MaxFrameSize)
+	}
+
+	// Rest of the frame: headers, payload and message CRC
+	rest := make([]byte, totalLen-preludeLen)
+	if _, err := io.ReadFull(d.r, rest); err != nil {
+		return Frame{}, truncated(err)
+	}
+
+	body := rest[:len(rest)-crcLen]
+	messageCRC := binary.BigEndian.Uint32(rest[len(rest)-crcLen:])
+	sum := crc32.Update(crc32.ChecksumIEEE(d.prelude[:]), crc32.IEEETable, body)
+	if sum != messageCRC {
+		return Frame{}, fmt.Errorf("%w: expected %08x, got %08x", ErrMessageChecksum, messageCRC, sum)
+	}
+
+	headers, err := decodeHeaders(body[:headerLen])
+	if err != nil {
+		return Frame{}, err
+	}
+
+	return Frame{
+		Headers: headers,
+		Payload: body[headerLen:],
+	}, nil
+}
+
+func truncated(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return ErrTruncatedFrame
+	}
+	return err
+}
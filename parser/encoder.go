@@ -0,0 +1,177 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"sort"
+	"time"
+)
+
+// Encoder writes AWS event-stream frames, the inverse of Decoder. It is
+// used to build test fixtures and scripted upstream responses.
+type Encoder struct {
+	w io.Writer
+}
+
+// NewEncoder returns an Encoder that writes frames to w.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+// Encode writes one frame with its prelude and message CRCs. Headers are
+// written in name order so that the output is deterministic.
+func (e *Encoder) Encode(frame Frame) error {
+	b, err := MarshalFrame(frame)
+	if err != nil {
+		return err
+	}
+	_, err = e.w.Write(b)
+	return err
+}
+
+// EncodeEvent writes an event frame of the given :event-type whose payload
+// is v marshalled as JSON.
+func (e *Encoder) EncodeEvent(eventType string, v any) error {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return e.Encode(Frame{
+		Headers: Headers{
+			HeaderEventType:   {Type: HeaderString, Value: eventType},
+			HeaderContentType: {Type: HeaderString, Value: "application/json"},
+			HeaderMessageType: {Type: HeaderString, Value: "event"},
+		},
+		Payload: payload,
+	})
+}
+
+// EncodeException writes an exception frame such as CodeWhisperer sends
+// when throttling or rejecting a request mid-stream.
+func (e *Encoder) EncodeException(exceptionType, message string) error {
+	payload, err := json.Marshal(map[string]string{"message": message})
+	if err != nil {
+		return err
+	}
+	return e.Encode(Frame{
+		Headers: Headers{
+			HeaderExceptionType: {Type: HeaderString, Value: exceptionType},
+			HeaderContentType:   {Type: HeaderString, Value: "application/json"},
+			HeaderMessageType:   {Type: HeaderString, Value: "exception"},
+		},
+		Payload: payload,
+	})
+}
+
+// MarshalFrame returns the wire encoding of frame.
+func MarshalFrame(frame Frame) ([]byte, error) {
+	headers, err := encodeHeaders(frame.Headers)
+	if err != nil {
+		return nil, err
+	}
+
+	totalLen := preludeLen + len(headers) + len(frame.Payload) + crcLen
+	buf := bytes.NewBuffer(make([]byte, 0, totalLen))
+
+	var prelude [preludeLen]byte
+	binary.BigEndian.PutUint32(prelude[0:4], uint32(totalLen))
+	binary.BigEndian.PutUint32(prelude[4:8], uint32(len(headers)))
+	binary.BigEndian.PutUint32(prelude[8:12], crc32.ChecksumIEEE(prelude[0:8]))
+	buf.Write(prelude[:])
+	buf.Write(headers)
+	buf.Write(frame.Payload)
+
+	var crc [crcLen]byte
+	binary.BigEndian.PutUint32(crc[:], crc32.ChecksumIEEE(buf.Bytes()))
+	buf.Write(crc[:])
+
+	return buf.Bytes(), nil
+}
+
+func encodeHeaders(headers Headers) ([]byte, error) {
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	for _, name := range names {
+		if len(name) == 0 || len(name) > 255 {
+			return nil, fmt.Errorf("%w: bad header name length %d", ErrInvalidHeader, len(name))
+		}
+		buf.WriteByte(byte(len(name)))
+		buf.WriteString(name)
+
+		v := headers[name]
+		buf.WriteByte(byte(v.Type))
+		if err := encodeHeaderValue(&buf, v); err != nil {
+			return nil, fmt.Errorf("%w: header %q: %v", ErrInvalidHeader, name, err)
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+func encodeHeaderValue(buf *bytes.Buffer, v HeaderValue) error {
+	var ok bool
+	switch v.Type {
+	case HeaderBoolTrue, HeaderBoolFalse:
+		return nil
+	case HeaderByte:
+		var b int8
+		if b, ok = v.Value.(int8); ok {
+			buf.WriteByte(byte(b))
+		}
+	case HeaderInt16:
+		var n int16
+		if n, ok = v.Value.(int16); ok {
+			binary.Write(buf, binary.BigEndian, n)
+		}
+	case HeaderInt32:
+		var n int32
+		if n, ok = v.Value.(int32); ok {
+			binary.Write(buf, binary.BigEndian, n)
+		}
+	case HeaderInt64:
+		var n int64
+		if n, ok = v.Value.(int64); ok {
+			binary.Write(buf, binary.BigEndian, n)
+		}
+	case HeaderTimestamp:
+		var ts time.Time
+		if ts, ok = v.Value.(time.Time); ok {
+			binary.Write(buf, binary.BigEndian, ts.UnixMilli())
+		}
+	case HeaderUUID:
+		var uuid [16]byte
+		if uuid, ok = v.Value.([16]byte); ok {
+			buf.Write(uuid[:])
+		}
+	case HeaderBytes, HeaderString:
+		var value []byte
+		switch val := v.Value.(type) {
+		case string:
+			value, ok = []byte(val), true
+		case []byte:
+			value, ok = val, true
+		}
+		if ok {
+			if len(value) > 0xffff {
+				return fmt.Errorf("value too long: %d bytes", len(value))
+			}
+			binary.Write(buf, binary.BigEndian, uint16(len(value)))
+			buf.Write(value)
+		}
+	default:
+		return fmt.Errorf("unknown value type %d", v.Type)
+	}
+
+	if !ok {
+		return fmt.Errorf("value %T does not match type %d", v.Value, v.Type)
+	}
+	return nil
+}
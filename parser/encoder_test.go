@@ -0,0 +1,88 @@
+package parser
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestEncoderRoundTrip(t *testing.T) {
+	frames := []Frame{
+		{
+			Headers: Headers{
+				"bool-true":  {Type: HeaderBoolTrue, Value: true},
+				"bool-false": {Type: HeaderBoolFalse, Value: false},
+				"byte":       {Type: HeaderByte, Value: int8(-3)},
+				"int16":      {Type: HeaderInt16, Value: int16(-300)},
+				"int32":      {Type: HeaderInt32, Value: int32(70000)},
+				"int64":      {Type: HeaderInt64, Value: int64(1) << 40},
+				"bytes":      {Type: HeaderBytes, Value: []byte{0, 1, 2}},
+				"string":     {Type: HeaderString, Value: "value"},
+				"timestamp":  {Type: HeaderTimestamp, Value: time.UnixMilli(1700000000123)},
+				"uuid":       {Type: HeaderUUID, Value: [16]byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}},
+			},
+			Payload: []byte(`{"content":"hi"}`),
+		},
+		{Headers: Headers{}, Payload: []byte{}},
+	}
+
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	for _, f := range frames {
+		if err := enc.Encode(f); err != nil {
+			t.Fatalf("Encode: %v", err)
+		}
+	}
+
+	dec := NewDecoder(&buf)
+	for i, want := range frames {
+		got, err := dec.Next()
+		if err != nil {
+			t.Fatalf("frame %d: Next: %v", i, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("frame %d: got %#v, want %#v", i, got, want)
+		}
+	}
+	if _, err := dec.Next(); err != io.EOF {
+		t.Errorf("after last frame: got %v, want io.EOF", err)
+	}
+}
+
+func TestDecoderErrors(t *testing.T) {
+	frame, err := MarshalFrame(Frame{
+		Headers: Headers{HeaderMessageType: {Type: HeaderString, Value: "event"}},
+		Payload: []byte(`{"content":"hi"}`),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	corrupt := func(i int) []byte {
+		b := append([]byte(nil), frame...)
+		b[i] ^= 0xff
+		return b
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"empty", nil, io.EOF},
+		{"short prelude", frame[:5], ErrTruncatedFrame},
+		{"short body", frame[:len(frame)-1], ErrTruncatedFrame},
+		{"prelude crc", corrupt(9), ErrPreludeChecksum},
+		{"message crc", corrupt(len(frame) - 6), ErrMessageChecksum},
+	}
+
+	for _, tt := range tests {
+		_, err := NewDecoder(bytes.NewReader(tt.data)).Next()
+		if !errors.Is(err, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package parser
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Anthropic error types, see https://docs.anthropic.com/en/api/errors
+const (
+	InvalidRequestError = "invalid_request_error"
+	AuthenticationError = "authentication_error"
+	PermissionError     = "permission_error"
+	NotFoundError       = "not_found_error"
+	RateLimitError      = "rate_limit_error"
+	APIError            = "api_error"
+	OverloadedError     = "overloaded_error"
+)
+
+// UpstreamError is an exception reported by CodeWhisperer, either as an
+// exception/error frame inside the event stream or as a non-200 HTTP reply.
+type UpstreamError struct {
+	ExceptionType string // e.g. "ThrottlingException"
+	Message       string
+	Status        int // upstream HTTP status, 0 for in-stream exceptions
+}
+
+func (e *UpstreamError) Error() string {
+	if e.ExceptionType == "" {
+		return e.Message
+	}
+	return fmt.Sprintf("%s: %s", e.ExceptionType, e.Message)
+}
+
+// ErrorType returns the Anthropic error.type matching the exception.
+func (e *UpstreamError) ErrorType() string {
+	switch e.ExceptionType {
+	case "ThrottlingException", "ServiceQuotaExceededException", "TooManyRequestsException":
+		return RateLimitError
+	case "ValidationException", "ContentLengthExceededException", "BadRequestException",
+		"SerializationException", "ConflictException", "InvalidStateException":
+		return InvalidRequestError
+	case "AccessDeniedException":
+		return PermissionError
+	case "UnauthorizedException", "ExpiredTokenException", "UnrecognizedClientException":
+		return AuthenticationError
+	case "ResourceNotFoundException":
+		return NotFoundError
+	case "ServiceUnavailableException", "ModelNotReadyException", "InsufficientModelCapacityException":
+		return OverloadedError
+	case "InternalServerException", "InternalFailureException", "DependencyFailedException":
+		return APIError
+	}
+
+	switch {
+	case e.Status == http.StatusTooManyRequests:
+		return RateLimitError
+	case e.Status == http.StatusUnauthorized:
+		return AuthenticationError
+	case e.Status == http.StatusForbidden:
+		return PermissionError
+	case e.Status == http.StatusNotFound:
+		return NotFoundError
+	case e.Status == http.StatusServiceUnavailable:
+		return OverloadedError
+	case e.Status >= 400 && e.Status < 500:
+		return InvalidRequestError
+	}
+	return APIError
+}
+
+// StatusCode returns the HTTP status Anthropic uses for ErrorType.
+func (e *UpstreamError) StatusCode() int {
+	return StatusForErrorType(e.ErrorType())
+}
+
+// Event renders the error as an Anthropic `error` SSE event.
+func (e *UpstreamError) Event() SSEEvent {
+	return ErrorEvent(e.ErrorType(), e.Error())
+}
+
+// StatusForErrorType maps an Anthropic error type to its HTTP status.
+func StatusForErrorType(errorType string) int {
+	switch errorType {
+	case InvalidRequestError:
+		return http.StatusBadRequest
+	case AuthenticationError:
+		return http.StatusUnauthorized
+	case PermissionError:
+		return http.StatusForbidden
+	case NotFoundError:
+		return http.StatusNotFound
+	case RateLimitError:
+		return http.StatusTooManyRequests
+	case OverloadedError:
+		return 529
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// ErrorEvent builds an Anthropic `error` SSE event.
+func ErrorEvent(errorType, message string) SSEEvent {
+	return SSEEvent{
+		Event: "error",
+		Data: map[string]interface{}{
+			"type": "error",
+			"error": map[string]interface{}{
+				"type":    errorType,
+				"message": message,
+			},
+		},
+	}
+}
+
+// exceptionPayload is the JSON body of exception frames and error replies.
+type exceptionPayload struct {
+	Type    string `json:"__type"`
+	Message string `json:"message"`
+	Reason  string `json:"reason"`
+}
+
+// frameError builds an UpstreamError from an exception or error frame.
+func frameError(frame Frame) *UpstreamError {
+	if frame.Headers.MessageType() == "error" {
+		return &UpstreamError{
+			ExceptionType: frame.Headers.Get(HeaderErrorCode),
+			Message:       frame.Headers.Get(HeaderErrorMessage),
+		}
+	}
+
+	upErr := &UpstreamError{ExceptionType: frame.Headers.ExceptionType()}
+	var p exceptionPayload
+	if err := json.Unmarshal(frame.Payload, &p); err == nil {
+		upErr.Message = p.Message
+		if upErr.ExceptionType == "" {
+			upErr.ExceptionType = exceptionName(p.Type)
+		}
+		if p.Reason != "" && upErr.ExceptionType == "ValidationException" {
+			upErr.Message = fmt.Sprintf("%s (%s)", p.Message, p.Reason)
+		}
+	} else {
+		upErr.Message = string(frame.Payload)
+	}
+	return upErr
+}
+
+// NewHTTPError builds an UpstreamError from a non-200 CodeWhisperer reply.
+func NewHTTPError(status int, body []byte) *UpstreamError {
+	upErr := &UpstreamError{Status: status, Message: strings.TrimSpace(string(body))}
+	var p exceptionPayload
+	if err := json.Unmarshal(body, &p); err == nil {
+		upErr.ExceptionType = exceptionName(p.Type)
+		if p.Message != "" {
+			upErr.Message = p.Message
+		}
+	}
+	if upErr.Message == "" {
+		upErr.Message = http.StatusText(status)
+	}
+	return upErr
+}
+
+// exceptionName strips the Smithy namespace from a __type value, e.g.
+// "com.amazon.aws.codewhisperer#ThrottlingException".
+func exceptionName(t string) string {
+	if i := strings.LastIndex(t, "#"); i >= 0 {
+		return t[i+1:]
+	}
+	return t
+}
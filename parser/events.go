@@ -0,0 +1,152 @@
+package parser
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// CodeWhisperer event types, as carried in the :event-type header.
+const (
+	EventAssistantResponse     = "assistantResponseEvent"
+	EventReasoningContent      = "reasoningContentEvent"
+	EventToolUse               = "toolUseEvent"
+	EventCodeReference         = "codeReferenceEvent"
+	EventFollowupPrompt        = "followupPromptEvent"
+	EventSupplementaryWebLinks = "supplementaryWebLinksEvent"
+	EventMessageMetadata       = "messageMetadataEvent"
+	EventMetadata              = "metadataEvent"
+	EventMetering              = "meteringEvent"
+	EventContextUsage          = "contextUsageEvent"
+	EventInvalidState          = "invalidStateEvent"
+)
+
+// AssistantResponseEvent carries a chunk of assistant text.
+type AssistantResponseEvent struct {
+	Content   string `json:"content"`
+	MessageId string `json:"messageId,omitempty"`
+}
+
+// ReasoningContentEvent carries a chunk of the model's reasoning. Text
+// streams the reasoning itself; Signature arrives once at the end of a
+// reasoning block. RedactedContent replaces Text when the reasoning was
+// withheld by the safety system.
+type ReasoningContentEvent struct {
+	Text            string `json:"text,omitempty"`
+	Signature       string `json:"signature,omitempty"`
+	RedactedContent []byte `json:"redactedContent,omitempty"`
+}
+
+// ToolUseEvent carries a tool call: the first event names the tool, the
+// following ones stream its input JSON, and the last one has Stop set.
+type ToolUseEvent struct {
+	Name      string  `json:"name"`
+	ToolUseId string  `json:"toolUseId"`
+	Input     *string `json:"input,omitempty"`
+	Stop      bool    `json:"stop"`
+}
+
+// CodeReferenceEvent lists licensed code the answer was derived from.
+type CodeReferenceEvent struct {
+	References []struct {
+		LicenseName string `json:"licenseName"`
+		Repository  string `json:"repository"`
+		Url         string `json:"url"`
+	} `json:"references"`
+}
+
+// FollowupPromptEvent suggests a follow-up question to the user.
+type FollowupPromptEvent struct {
+	FollowupPrompt struct {
+		Content    string `json:"content"`
+		UserIntent string `json:"userIntent,omitempty"`
+	} `json:"followupPrompt"`
+}
+
+// SupplementaryWebLinksEvent lists web pages related to the answer.
+type SupplementaryWebLinksEvent struct {
+	SupplementaryWebLinks []struct {
+		Title   string `json:"title"`
+		Url     string `json:"url"`
+		Snippet string `json:"snippet,omitempty"`
+	} `json:"supplementaryWebLinks"`
+}
+
+// MessageMetadataEvent identifies the conversation the response belongs to.
+type MessageMetadataEvent struct {
+	ConversationId string `json:"conversationId"`
+	UtteranceId    string `json:"utteranceId,omitempty"`
+}
+
+// MetadataEvent reports token usage for the whole response.
+type MetadataEvent struct {
+	TokenUsage *struct {
+		UncachedInputTokens   int `json:"uncachedInputTokens"`
+		OutputTokens          int `json:"outputTokens"`
+		TotalTokens           int `json:"totalTokens"`
+		CacheReadInputTokens  int `json:"cacheReadInputTokens"`
+		CacheWriteInputTokens int `json:"cacheWriteInputTokens"`
+	} `json:"tokenUsage,omitempty"`
+}
+
+// MeteringEvent reports the billing units consumed by the request.
+type MeteringEvent struct {
+	Unit       string  `json:"unit"`
+	UnitPlural string  `json:"unitPlural"`
+	Usage      float64 `json:"usage"`
+}
+
+// ContextUsageEvent reports how much of the context window is in use.
+type ContextUsageEvent struct {
+	ContextUsagePercentage float64 `json:"contextUsagePercentage"`
+}
+
+// InvalidStateEvent reports that the conversation cannot continue.
+type InvalidStateEvent struct {
+	Reason  string `json:"reason"`
+	Message string `json:"message"`
+}
+
+// UnknownEvent is returned by DecodeEvent for event types it does not know.
+type UnknownEvent struct {
+	Type    string
+	Payload []byte
+}
+
+// DecodeEvent decodes the payload of an event frame into the typed struct
+// matching its :event-type header and returns a pointer to it.
+func DecodeEvent(frame Frame) (any, error) {
+	eventType := frame.Headers.EventType()
+
+	var evt any
+	switch eventType {
+	case EventAssistantResponse:
+		evt = &AssistantResponseEvent{}
+	case EventReasoningContent:
+		evt = &ReasoningContentEvent{}
+	case EventToolUse:
+		evt = &ToolUseEvent{}
+	case EventCodeReference:
+		evt = &CodeReferenceEvent{}
+	case EventFollowupPrompt:
+		evt = &FollowupPromptEvent{}
+	case EventSupplementaryWebLinks:
+		evt = &SupplementaryWebLinksEvent{}
+	case EventMessageMetadata:
+		evt = &MessageMetadataEvent{}
+	case EventMetadata:
+		evt = &MetadataEvent{}
+	case EventMetering:
+		evt = &MeteringEvent{}
+	case EventContextUsage:
+		evt = &ContextUsageEvent{}
+	case EventInvalidState:
+		evt = &InvalidStateEvent{}
+	default:
+		return &UnknownEvent{Type: eventType, Payload: frame.Payload}, nil
+	}
+
+	if err := json.Unmarshal(frame.Payload, evt); err != nil {
+		return nil, fmt.Errorf("decode %s: %w", eventType, err)
+	}
+	return evt, nil
+}
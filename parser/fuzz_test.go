@@ -0,0 +1,108 @@
+package parser
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// addFixtureSeeds seeds the corpus with the checked-in golden responses.
+func addFixtureSeeds(f *testing.F) {
+	paths, _ := filepath.Glob(filepath.Join("testdata", "*.raw"))
+	for _, path := range paths {
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(raw)
+	}
+	f.Add([]byte{})
+	f.Add([]byte{0, 0, 0, 16, 0, 0, 0, 0})
+}
+
+func FuzzDecoder(f *testing.F) {
+	addFixtureSeeds(f)
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		dec := NewDecoder(bytes.NewReader(data))
+		dec.MaxFrameSize = 1 << 16
+		for {
+			frame, err := dec.Next()
+			if err != nil {
+				return
+			}
+
+			// Whatever decodes must re-encode to an equivalent frame.
+			raw, err := MarshalFrame(frame)
+			if err != nil {
+				t.Fatalf("MarshalFrame of decoded frame: %v", err)
+			}
+			again, err := NewDecoder(bytes.NewReader(raw)).Next()
+			if err != nil {
+				t.Fatalf("decode of re-encoded frame: %v", err)
+			}
+			if !reflect.DeepEqual(normalize(frame), normalize(again)) {
+				t.Fatalf("round trip mismatch:\n%#v\n%#v", frame, again)
+			}
+		}
+	})
+}
+
+func FuzzParseResponse(f *testing.F) {
+	addFixtureSeeds(f)
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		_, err := ParseResponse(data)
+		if errors.Is(err, ErrMalformedStream) {
+			t.Fatalf("translator panicked: %v", err)
+		}
+	})
+}
+
+// normalize makes empty and nil payloads compare equal.
+func normalize(f Frame) Frame {
+	if len(f.Payload) == 0 {
+		f.Payload = nil
+	}
+	return f
+}
+
+func TestDecoderMaxFrameSize(t *testing.T) {
+	raw, err := MarshalFrame(Frame{Headers: Headers{}, Payload: make([]byte, 64)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dec := NewDecoder(bytes.NewReader(raw))
+	dec.MaxFrameSize = 32
+	if _, err := dec.Next(); !errors.Is(err, ErrFrameTooLarge) {
+		t.Errorf("got %v, want ErrFrameTooLarge", err)
+	}
+
+	tr := NewTranslator()
+	tr.MaxFrameSize = 32
+	if _, err := tr.ParseResponse(raw); !errors.Is(err, ErrFrameTooLarge) {
+		t.Errorf("Translator: got %v, want ErrFrameTooLarge", err)
+	}
+
+	if _, err := NewDecoder(bytes.NewReader(raw)).Next(); err != nil {
+		t.Errorf("default limit: unexpected error %v", err)
+	}
+}
+
+func TestStreamRecoversFromPanic(t *testing.T) {
+	raw, err := os.ReadFile(filepath.Join("testdata", "text.raw"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = NewTranslator().Stream(bytes.NewReader(raw), func(SSEEvent) {
+		panic("boom")
+	})
+	if !errors.Is(err, ErrMalformedStream) {
+		t.Errorf("got %v, want ErrMalformedStream", err)
+	}
+}
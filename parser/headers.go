@@ -0,0 +1,175 @@
+package parser
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// HeaderType is the wire type tag of an event-stream header value.
+type HeaderType byte
+
+const (
+	HeaderBoolTrue  HeaderType = 0
+	HeaderBoolFalse HeaderType = 1
+	HeaderByte      HeaderType = 2
+	HeaderInt16     HeaderType = 3
+	HeaderInt32     HeaderType = 4
+	HeaderInt64     HeaderType = 5
+	HeaderBytes     HeaderType = 6
+	HeaderString    HeaderType = 7
+	HeaderTimestamp HeaderType = 8
+	HeaderUUID      HeaderType = 9
+)
+
+// Well-known header names used by CodeWhisperer streams.
+const (
+	HeaderEventType     = ":event-type"
+	HeaderMessageType   = ":message-type"
+	HeaderContentType   = ":content-type"
+	HeaderExceptionType = ":exception-type"
+	HeaderErrorCode     = ":error-code"
+	HeaderErrorMessage  = ":error-message"
+)
+
+// HeaderValue is a decoded header value. Value holds a bool, int8, int16,
+// int32, int64, []byte, string, time.Time or [16]byte depending on Type.
+type HeaderValue struct {
+	Type  HeaderType
+	Value any
+}
+
+// String renders the value as text; string and byte array values are
+// returned verbatim.
+func (v HeaderValue) String() string {
+	switch val := v.Value.(type) {
+	case string:
+		return val
+	case []byte:
+		return string(val)
+	case [16]byte:
+		return hex.EncodeToString(val[:])
+	case time.Time:
+		return val.UTC().Format(time.RFC3339Nano)
+	case nil:
+		return ""
+	default:
+		return fmt.Sprint(val)
+	}
+}
+
+// Headers holds the decoded header section of a frame, keyed by header name.
+type Headers map[string]HeaderValue
+
+// Get returns the textual value of the named header, or "" when absent.
+func (h Headers) Get(name string) string {
+	v, ok := h[name]
+	if !ok {
+		return ""
+	}
+	return v.String()
+}
+
+// EventType returns the :event-type header, e.g. "assistantResponseEvent".
+func (h Headers) EventType() string { return h.Get(HeaderEventType) }
+
+// MessageType returns the :message-type header: "event", "exception" or "error".
+func (h Headers) MessageType() string { return h.Get(HeaderMessageType) }
+
+// ContentType returns the :content-type header.
+func (h Headers) ContentType() string { return h.Get(HeaderContentType) }
+
+// ExceptionType returns the :exception-type header of exception frames.
+func (h Headers) ExceptionType() string { return h.Get(HeaderExceptionType) }
+
+// decodeHeaders parses the header section. Each header is encoded as
+// name length (1 byte), name, value type (1 byte) and the typed value.
+func decodeHeaders(b []byte) (Headers, error) {
+	headers := Headers{}
+	for len(b) > 0 {
+		nameLen := int(b[0])
+		b = b[1:]
+		if nameLen == 0 || len(b) < nameLen+1 {
+			return nil, fmt.Errorf("%w: bad header name length %d", ErrInvalidHeader, nameLen)
+		}
+		name := string(b[:nameLen])
+		valueType := HeaderType(b[nameLen])
+		b = b[nameLen+1:]
+
+		value, n, err := decodeHeaderValue(valueType, b)
+		if err != nil {
+			return nil, fmt.Errorf("%w: header %q: %v", ErrInvalidHeader, name, err)
+		}
+		headers[name] = HeaderValue{Type: valueType, Value: value}
+		b = b[n:]
+	}
+	return headers, nil
+}
+
+// decodeHeaderValue decodes a value of type t from the front of b and
+// reports how many bytes it consumed.
+func decodeHeaderValue(t HeaderType, b []byte) (any, int, error) {
+	need := func(n int) error {
+		if len(b) < n {
+			return fmt.Errorf("value needs %d bytes, %d left", n, len(b))
+		}
+		return nil
+	}
+
+	switch t {
+	case HeaderBoolTrue:
+		return true, 0, nil
+	case HeaderBoolFalse:
+		return false, 0, nil
+	case HeaderByte:
+		if err := need(1); err != nil {
+			return nil, 0, err
+		}
+		return int8(b[0]), 1, nil
+	case HeaderInt16:
+		if err := need(2); err != nil {
+			return nil, 0, err
+		}
+		return int16(binary.BigEndian.Uint16(b)), 2, nil
+	case HeaderInt32:
+		if err := need(4); err != nil {
+			return nil, 0, err
+		}
+		return int32(binary.BigEndian.Uint32(b)), 4, nil
+	case HeaderInt64:
+		if err := need(8); err != nil {
+			return nil, 0, err
+		}
+		return int64(binary.BigEndian.Uint64(b)), 8, nil
+	case HeaderTimestamp:
+		if err := need(8); err != nil {
+			return nil, 0, err
+		}
+		ms := int64(binary.BigEndian.Uint64(b))
+		return time.UnixMilli(ms), 8, nil
+	case HeaderUUID:
+		if err := need(16); err != nil {
+			return nil, 0, err
+		}
+		var uuid [16]byte
+		copy(uuid[:], b)
+		return uuid, 16, nil
+	case HeaderBytes, HeaderString:
+		if err := need(2); err != nil {
+			return nil, 0, err
+		}
+		valueLen := int(binary.BigEndian.Uint16(b))
+		if err := need(2 + valueLen); err != nil {
+			return nil, 0, err
+		}
+		if t == HeaderString {
+			return string(b[2 : 2+valueLen]), 2 + valueLen, nil
+		}
+		value := make([]byte, valueLen)
+		copy(value, b[2:2+valueLen])
+		return value, 2 + valueLen, nil
+	default:
+		return nil, 0, fmt.Errorf("unknown value type %d", t)
+	}
+}
@@ -0,0 +1,210 @@
+package parser
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// scanStopSequences emits text up to the first stop sequence. Text that
+// could be the beginning of a sequence split across chunks is held back
+// until the next chunk or the end of the block decides it.
+func (t *Translator) scanStopSequences(text string) []SSEEvent {
+	buf := t.pendingText + text
+	t.pendingText = ""
+
+	match, at := "", -1
+	for _, seq := range t.StopSequences {
+		if seq == "" {
+			continue
+		}
+		if i := strings.Index(buf, seq); i >= 0 && (at < 0 || i < at) {
+			match, at = seq, i
+		}
+	}
+
+	if at >= 0 {
+		var events []SSEEvent
+		if at > 0 {
+			events = append(events, t.textDelta(buf[:at]))
+		}
+		events = append(events, t.closeBlock()...)
+		t.stopReason = "stop_sequence"
+		t.stopSequence = match
+		return events
+	}
+
+	keep := 0
+	for _, seq := range t.StopSequences {
+		if n := partialSuffix(buf, seq); n > keep {
+			keep = n
+		}
+	}
+	cut := len(buf) - keep
+	for cut > 0 && cut < len(buf) && !utf8.RuneStart(buf[cut]) {
+		cut--
+	}
+
+	t.pendingText = buf[cut:]
+	if cut == 0 {
+		return nil
+	}
+	return []SSEEvent{t.textDelta(buf[:cut])}
+}
+
+// partialSuffix returns the length of the longest suffix of s that is a
+// proper prefix of seq.
+func partialSuffix(s, seq string) int {
+	for n := len(seq) - 1; n > 0; n-- {
+		if strings.HasSuffix(s, seq[:n]) {
+			return n
+		}
+	}
+	return 0
+}
+
+// acceptToolUse applies AllowTool and MaxToolUses to a tool call. The
+// decision is made on the call's first event and sticks for the rest.
+func (t *Translator) acceptToolUse(evt *ToolUseEvent) bool {
+	if t.droppedTools[evt.ToolUseId] {
+		return false
+	}
+	if t.open != nil && t.open.toolUseId == evt.ToolUseId {
+		return true
+	}
+	if evt.Stop && evt.Input == nil {
+		return true // stray stop, ignored by translateToolUse
+	}
+
+	if (t.AllowTool != nil && !t.AllowTool(evt.Name)) || (t.MaxToolUses > 0 && t.toolUses >= t.MaxToolUses) {
+		if t.droppedTools == nil {
+			t.droppedTools = map[string]bool{}
+		}
+		t.droppedTools[evt.ToolUseId] = true
+		return false
+	}
+
+	t.toolUses++
+	return true
+}
+
+// OutputTokens returns the number of tokens passed on so far, as counted by
+// CountTokens. Unlike TokenUsage it is always available and reflects any
+// truncation done by the proxy.
+func (t *Translator) OutputTokens() int {
+	return t.outputTokens
+}
+
+func (t *Translator) countTokens(text string) int {
+	if t.CountTokens != nil {
+		return t.CountTokens(text)
+	}
+	return (len(text) + 3) / 4
+}
+
+// maxCountTail bounds the uncounted tail of a block, so text without
+// whitespace is not recounted from its start on every delta.
+const maxCountTail = 256
+
+// tokenCount tracks the tokens of the current block incrementally. Counting
+// every delta on its own would split words at chunk boundaries and
+// overcount, so text is committed up to its last whitespace and the tail is
+// recounted together with the next delta.
+type tokenCount struct {
+	committed int
+	tail      string
+}
+
+func (t *Translator) addTokens(c tokenCount, text string) (tokenCount, int) {
+	buf := c.tail + text
+	cut := strings.LastIndexAny(buf, " \t\n")
+	if cut <= 0 && len(buf) > maxCountTail {
+		cut = len(buf) - maxCountTail
+		for cut > 0 && !utf8.RuneStart(buf[cut]) {
+			cut--
+		}
+	}
+	if cut > 0 {
+		c = tokenCount{committed: c.committed + t.countTokens(buf[:cut]), tail: buf[cut:]}
+	} else {
+		c.tail = buf
+	}
+	return c, c.committed + t.countTokens(c.tail)
+}
+
+// deltaFields maps each delta type that carries output to its text field.
+var deltaFields = map[string]string{
+	"text_delta":       "text",
+	"thinking_delta":   "thinking",
+	"input_json_delta": "partial_json",
+}
+
+// limitTokens counts the output carried by events and, once MaxTokens is
+// reached, cuts the delta that crosses it, closes its block and drops the
+// rest of the response.
+func (t *Translator) limitTokens(events []SSEEvent) []SSEEvent {
+	for i, e := range events {
+		if e.Event == "content_block_start" {
+			t.blockTokens = tokenCount{committed: t.outputTokens}
+		}
+		if e.Event != "content_block_delta" {
+			continue
+		}
+		data := e.Data.(map[string]interface{})
+		delta := data["delta"].(map[string]interface{})
+		field, ok := deltaFields[delta["type"].(string)]
+		if !ok {
+			continue
+		}
+
+		text := delta[field].(string)
+		count, total := t.addTokens(t.blockTokens, text)
+		if t.MaxTokens > 0 && total > t.MaxTokens {
+			text = t.truncateTokens(text)
+			delta[field] = text
+			count, total = t.addTokens(t.blockTokens, text)
+		}
+		t.blockTokens, t.outputTokens = count, total
+		if t.MaxTokens <= 0 || t.outputTokens < t.MaxTokens {
+			continue
+		}
+
+		t.stopReason = "max_tokens"
+		t.open = nil
+		t.pendingText = ""
+
+		out := events[:i:i]
+		if text != "" {
+			out = append(out, e)
+		}
+		return append(out, SSEEvent{
+			Event: "content_block_stop",
+			Data: map[string]interface{}{
+				"type":  "content_block_stop",
+				"index": data["index"],
+			},
+		})
+	}
+	return events
+}
+
+// truncateTokens returns the longest prefix of text, cut at a rune
+// boundary, that still fits in MaxTokens.
+func (t *Translator) truncateTokens(text string) string {
+	var cuts []int
+	for i := range text {
+		cuts = append(cuts, i)
+	}
+	cuts = append(cuts, len(text))
+
+	// cuts[0] is the empty prefix, which always fits.
+	lo, hi := 0, len(cuts)-1
+	for lo < hi {
+		mid := (lo + hi + 1) / 2
+		if _, total := t.addTokens(t.blockTokens, text[:cuts[mid]]); total <= t.MaxTokens {
+			lo = mid
+		} else {
+			hi = mid - 1
+		}
+	}
+	return text[:cuts[lo]]
+}
@@ -0,0 +1,266 @@
+package parser
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// encode builds a raw CodeWhisperer stream from a script.
+func encode(script func(enc *Encoder)) []byte {
+	var buf bytes.Buffer
+	script(NewEncoder(&buf))
+	return buf.Bytes()
+}
+
+// collectText joins the text deltas of a translated response.
+func collectText(events []SSEEvent) string {
+	var text string
+	for _, e := range events {
+		data, ok := e.Data.(map[string]interface{})
+		if !ok || e.Event != "content_block_delta" {
+			continue
+		}
+		if delta, ok := data["delta"].(map[string]interface{}); ok && delta["type"] == "text_delta" {
+			text += delta["text"].(string)
+		}
+	}
+	return text
+}
+
+func TestTranslatorStopSequences(t *testing.T) {
+	tests := []struct {
+		name      string
+		chunks    []string
+		sequences []string
+		wantText  string
+		wantSeq   string
+	}{
+		{"no match", []string{"Hello", ", world"}, []string{"END"}, "Hello, world", ""},
+		{"single chunk", []string{"one\nEND\ntwo"}, []string{"END"}, "one\n", "END"},
+		{"split across chunks", []string{"count: 1 2 ST", "OP 3"}, []string{"STOP"}, "count: 1 2 ", "STOP"},
+		{"earliest wins", []string{"a ## b --- c"}, []string{"---", "##"}, "a ", "##"},
+		{"held prefix released", []string{"x <", "y"}, []string{"<END>"}, "x <y", ""},
+		{"at start", []string{"###rest"}, []string{"###"}, "", "###"},
+		{"multibyte", []string{"日本", "語です"}, []string{"です"}, "日本語", "です"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw := encode(func(enc *Encoder) {
+				for _, c := range tt.chunks {
+					enc.EncodeEvent(EventAssistantResponse, AssistantResponseEvent{Content: c})
+				}
+				enc.EncodeEvent(EventToolUse, ToolUseEvent{Name: "Read", ToolUseId: "tooluse_1", Input: strPtr(`{}`), Stop: true})
+			})
+
+			tr := NewTranslator()
+			tr.StopSequences = tt.sequences
+			events, err := tr.ParseResponse(raw)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := collectText(events); got != tt.wantText {
+				t.Errorf("text = %q, want %q", got, tt.wantText)
+			}
+			if got := tr.StopSequence(); got != tt.wantSeq {
+				t.Errorf("StopSequence() = %q, want %q", got, tt.wantSeq)
+			}
+
+			wantReason := "tool_use"
+			if tt.wantSeq != "" {
+				wantReason = "stop_sequence"
+			}
+			if got := tr.StopReason(); got != wantReason {
+				t.Errorf("StopReason() = %q, want %q", got, wantReason)
+			}
+
+			opened := 0
+			for _, e := range events {
+				switch e.Event {
+				case "content_block_start":
+					opened++
+				case "content_block_stop":
+					opened--
+				}
+			}
+			if opened != 0 {
+				t.Errorf("%d content blocks left open", opened)
+			}
+		})
+	}
+}
+
+func TestTranslatorToolFilter(t *testing.T) {
+	raw := encode(func(enc *Encoder) {
+		enc.EncodeEvent(EventToolUse, ToolUseEvent{Name: "Bash", ToolUseId: "tooluse_1"})
+		enc.EncodeEvent(EventToolUse, ToolUseEvent{Name: "Bash", ToolUseId: "tooluse_1", Input: strPtr(`{"command":"ls"}`)})
+		enc.EncodeEvent(EventToolUse, ToolUseEvent{Name: "Bash", ToolUseId: "tooluse_1", Stop: true})
+		enc.EncodeEvent(EventToolUse, ToolUseEvent{Name: "Read", ToolUseId: "tooluse_2", Input: strPtr(`{"path":"a.go"}`), Stop: true})
+		enc.EncodeEvent(EventToolUse, ToolUseEvent{Name: "Read", ToolUseId: "tooluse_3", Input: strPtr(`{"path":"b.go"}`), Stop: true})
+	})
+
+	tests := []struct {
+		name     string
+		allow    func(string) bool
+		maxUses  int
+		wantIDs  []string
+		wantStop string
+	}{
+		{"unfiltered", nil, 0, []string{"tooluse_1", "tooluse_2", "tooluse_3"}, "tool_use"},
+		{"allow list", func(name string) bool { return name == "Read" }, 0, []string{"tooluse_2", "tooluse_3"}, "tool_use"},
+		{"max uses", nil, 1, []string{"tooluse_1"}, "tool_use"},
+		{"allow and max", func(name string) bool { return name == "Read" }, 1, []string{"tooluse_2"}, "tool_use"},
+		{"none allowed", func(string) bool { return false }, 0, nil, "end_turn"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := NewTranslator()
+			tr.AllowTool = tt.allow
+			tr.MaxToolUses = tt.maxUses
+			events, err := tr.ParseResponse(raw)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var ids []string
+			for i, e := range events {
+				if e.Event != "content_block_start" {
+					continue
+				}
+				data := e.Data.(map[string]interface{})
+				if data["index"] != len(ids) {
+					t.Errorf("event %d: index = %v, want %d", i, data["index"], len(ids))
+				}
+				block := data["content_block"].(map[string]interface{})
+				ids = append(ids, block["id"].(string))
+			}
+			if len(ids) != len(tt.wantIDs) {
+				t.Fatalf("tool calls = %v, want %v", ids, tt.wantIDs)
+			}
+			for i := range ids {
+				if ids[i] != tt.wantIDs[i] {
+					t.Errorf("tool calls = %v, want %v", ids, tt.wantIDs)
+					break
+				}
+			}
+			if got := tr.StopReason(); got != tt.wantStop {
+				t.Errorf("StopReason() = %q, want %q", got, tt.wantStop)
+			}
+		})
+	}
+}
+
+func TestTranslatorMaxTokens(t *testing.T) {
+	raw := encode(func(enc *Encoder) {
+		enc.EncodeEvent(EventAssistantResponse, AssistantResponseEvent{Content: "Hello, "})
+		enc.EncodeEvent(EventAssistantResponse, AssistantResponseEvent{Content: "wörld!"})
+		enc.EncodeEvent(EventToolUse, ToolUseEvent{Name: "Read", ToolUseId: "tooluse_1", Input: strPtr(`{"path":"a.go"}`), Stop: true})
+	})
+
+	tests := []struct {
+		maxTokens  int
+		wantText   string
+		wantTool   bool
+		wantReason string
+		wantTokens int
+	}{
+		{0, "Hello, wörld!", true, "tool_use", 28},
+		{100, "Hello, wörld!", true, "tool_use", 28},
+		{13, "Hello, wörld!", false, "max_tokens", 13},
+		{9, "Hello, wö", false, "max_tokens", 9},
+		{7, "Hello, ", false, "max_tokens", 7},
+		{3, "Hel", false, "max_tokens", 3},
+	}
+
+	for _, tt := range tests {
+		tr := NewTranslator()
+		tr.MaxTokens = tt.maxTokens
+		tr.CountTokens = utf8.RuneCountInString // one token per character
+		events, err := tr.ParseResponse(raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := collectText(events); got != tt.wantText {
+			t.Errorf("max_tokens %d: text = %q, want %q", tt.maxTokens, got, tt.wantText)
+		}
+		hasTool := false
+		opened := 0
+		for _, e := range events {
+			switch e.Event {
+			case "content_block_start":
+				opened++
+				block := e.Data.(map[string]interface{})["content_block"].(map[string]interface{})
+				hasTool = hasTool || block["type"] == "tool_use"
+			case "content_block_stop":
+				opened--
+			}
+		}
+		if hasTool != tt.wantTool {
+			t.Errorf("max_tokens %d: tool call passed on = %v, want %v", tt.maxTokens, hasTool, tt.wantTool)
+		}
+		if opened != 0 {
+			t.Errorf("max_tokens %d: %d content blocks left open", tt.maxTokens, opened)
+		}
+		if got := tr.StopReason(); got != tt.wantReason {
+			t.Errorf("max_tokens %d: StopReason() = %q, want %q", tt.maxTokens, got, tt.wantReason)
+		}
+		if got := tr.OutputTokens(); got != tt.wantTokens {
+			t.Errorf("max_tokens %d: OutputTokens() = %d, want %d", tt.maxTokens, got, tt.wantTokens)
+		}
+	}
+}
+
+func TestTranslatorMaxTokensCutsToolInput(t *testing.T) {
+	raw := encode(func(enc *Encoder) {
+		enc.EncodeEvent(EventToolUse, ToolUseEvent{Name: "Write", ToolUseId: "tooluse_1"})
+		enc.EncodeEvent(EventToolUse, ToolUseEvent{Name: "Write", ToolUseId: "tooluse_1", Input: strPtr(`{"content":"`)})
+		enc.EncodeEvent(EventToolUse, ToolUseEvent{Name: "Write", ToolUseId: "tooluse_1", Input: strPtr(`a long file"}`)})
+		enc.EncodeEvent(EventToolUse, ToolUseEvent{Name: "Write", ToolUseId: "tooluse_1", Stop: true})
+	})
+
+	tr := NewTranslator()
+	tr.MaxTokens = 5 // four bytes per token by default
+	events, err := tr.ParseResponse(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var input string
+	for _, e := range events {
+		if e.Event != "content_block_delta" {
+			continue
+		}
+		delta := e.Data.(map[string]interface{})["delta"].(map[string]interface{})
+		input += delta["partial_json"].(string)
+	}
+	if want := `{"content":"a lon`; input != want {
+		t.Errorf("tool input = %q, want %q", input, want)
+	}
+	if last := events[len(events)-1]; last.Event != "content_block_stop" {
+		t.Errorf("last event = %s, want content_block_stop", last.Event)
+	}
+	if got := tr.StopReason(); got != "max_tokens" {
+		t.Errorf("StopReason() = %q, want max_tokens", got)
+	}
+}
+
+func TestTranslatorOutputTokensAcrossChunks(t *testing.T) {
+	raw := encode(func(enc *Encoder) {
+		for _, c := range []string{"Hel", "lo wo", "rld, ", "how are", " you?"} {
+			enc.EncodeEvent(EventAssistantResponse, AssistantResponseEvent{Content: c})
+		}
+	})
+
+	tr := NewTranslator()
+	tr.CountTokens = func(s string) int { return len(strings.Fields(s)) } // one token per word
+	if _, err := tr.ParseResponse(raw); err != nil {
+		t.Fatal(err)
+	}
+	if got := tr.OutputTokens(); got != 5 {
+		t.Errorf("OutputTokens() = %d, want 5: words split across chunks were counted twice", got)
+	}
+}
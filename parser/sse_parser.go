@@ -2,20 +2,15 @@ package parser
 
 import (
 	"bytes"
-	"encoding/binary"
-	"encoding/json"
+	"encoding/base64"
+	"errors"
+	"fmt"
 	"io"
 	"log"
-	"strings"
 )
 
-type assistantResponseEvent struct {
-	Content   string  `json:"content"`
-	Input     *string `json:"input,omitempty"`
-	Name      string  `json:"name"`
-	ToolUseId string  `json:"toolUseId"`
-	Stop      bool    `json:"stop"`
-}
+// ErrMalformedStream is returned when translating a response panicked.
+var ErrMalformedStream = errors.New("event stream: malformed response")
 
 type SSEEvent struct {
 	Event string      `json:"event"`
@@ -23,130 +18,422 @@ type SSEEvent struct {
 }
 
 func ParseEvents(resp []byte) []SSEEvent {
+	events, _ := ParseResponse(resp)
+	return events
+}
+
+// ParseResponse translates a complete response body. Alongside the events
+// decoded so far it returns the error that stopped decoding, which is an
+// *UpstreamError when CodeWhisperer sent an exception frame.
+func ParseResponse(resp []byte) ([]SSEEvent, error) {
+	return NewTranslator().ParseResponse(resp)
+}
+
+// ParseStream reads event-stream frames from r as they arrive and calls emit
+// for every translated SSE event, so callers can forward them without
+// buffering the whole response. It returns nil once r is drained and an
+// *UpstreamError when the stream carries an exception or error frame.
+func ParseStream(r io.Reader, emit func(SSEEvent)) error {
+	return NewTranslator().Stream(r, emit)
+}
+
+// Translator converts the events of one CodeWhisperer response into
+// Anthropic SSE events and records the response-level metadata (metering,
+// token usage, conversation id) that does not map to a content event.
+//
+// It also tracks the open content block so that every text run and every
+// tool call gets its own increasing index, framed by content_block_start
+// and content_block_stop, as Anthropic clients expect.
+type Translator struct {
+	// MaxFrameSize overrides Decoder.MaxFrameSize when non-zero.
+	MaxFrameSize uint32
+	// DropThinking discards reasoning events instead of emitting thinking
+	// blocks, for clients that did not ask for extended thinking.
+	DropThinking bool
+	// StopSequences ends the response at the first occurrence of any of
+	// these strings in the text; the sequence itself is not emitted.
+	StopSequences []string
+	// AllowTool, when set, decides which tool calls are passed on; calls it
+	// rejects are dropped entirely.
+	AllowTool func(name string) bool
+	// MaxToolUses caps the number of tool calls passed on; 0 means no limit.
+	MaxToolUses int
+	// MaxTokens ends the response once the text, thinking and tool input
+	// passed on reach this many tokens; 0 means no limit.
+	MaxTokens int
+	// CountTokens counts the tokens of a piece of output. It defaults to an
+	// estimate of four bytes per token.
+	CountTokens func(text string) int
+
+	ConversationId         string
+	UtteranceId            string
+	ContextUsagePercentage float64
+	Metering               *MeteringEvent
+	Usage                  *MetadataEvent
+
+	nextIndex     int
+	open          *contentBlock
+	lastBlockType string
+
+	pendingText  string          // text held back while it may start a stop sequence
+	stopReason   string          // set when the proxy ended the response early
+	stopSequence string          // the stop sequence that ended the response
+	toolUses     int             // tool calls passed on
+	droppedTools map[string]bool // tool calls rejected by AllowTool or MaxToolUses
+	outputTokens int             // tokens passed on, as counted by CountTokens
+	blockTokens  tokenCount      // running count of the open block
+}
+
+// contentBlock is the content block currently being streamed.
+type contentBlock struct {
+	index     int
+	blockType string // "text", "thinking", "redacted_thinking" or "tool_use"
+	toolUseId string
+}
+
+// NewTranslator returns a Translator for a new response.
+func NewTranslator() *Translator {
+	return &Translator{}
+}
+
+// ParseResponse translates a complete response body, see ParseResponse.
+func (t *Translator) ParseResponse(resp []byte) ([]SSEEvent, error) {
 
 	events := []SSEEvent{}
 
-	r := bytes.NewReader(resp)
+	err := t.Stream(bytes.NewReader(resp), func(e SSEEvent) {
+		events = append(events, e)
+	})
+
+	return events, err
+}
+
+// Stream decodes frames from r and emits their translation, see ParseStream.
+// A panic while handling malformed input is recovered and returned as an
+// error wrapping ErrMalformedStream, so one bad upstream response cannot
+// take the process down.
+func (t *Translator) Stream(r io.Reader, emit func(SSEEvent)) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			log.Printf("recovered from panic while parsing event stream: %v", p)
+			err = fmt.Errorf("%w: %v", ErrMalformedStream, p)
+		}
+	}()
+
+	dec := NewDecoder(r)
+	if t.MaxFrameSize > 0 {
+		dec.MaxFrameSize = t.MaxFrameSize
+	}
 	for {
-		if r.Len() < 12 {
-			break
+		frame, err := dec.Next()
+		if err != nil {
+			if err == io.EOF {
+				for _, e := range t.Finish() {
+					emit(e)
+				}
+				return nil
+			}
+			log.Println("event stream decode error:", err)
+			return err
+		}
+
+		switch frame.Headers.MessageType() {
+		case "exception", "error":
+			upErr := frameError(frame)
+			log.Println("upstream exception:", upErr)
+			return upErr
+		}
+
+		evt, err := DecodeEvent(frame)
+		if err != nil {
+			log.Println("json unmarshal error:", err)
+			continue
 		}
 
-		var totalLen, headerLen uint32
-		if err := binary.Read(r, binary.BigEndian, &totalLen); err != nil {
-			break
+		events, err := t.Translate(evt)
+		if err != nil {
+			return err
 		}
-		if err := binary.Read(r, binary.BigEndian, &headerLen); err != nil {
-			break
+		for _, e := range events {
+			emit(e)
 		}
 
-		if int(totalLen) > r.Len()+8 {
-			log.Println("Frame length invalid")
-			break
+		// The rest of the upstream response is not needed once a stop
+		// sequence or max_tokens ended it.
+		if t.stopReason != "" {
+			return nil
 		}
+	}
+}
+
+// Translate converts one decoded event (as returned by DecodeEvent) into
+// the SSE events to send to the client, which may be none. Call Finish once
+// the response ends to close the last content block.
+func (t *Translator) Translate(evt any) ([]SSEEvent, error) {
+	if t.stopReason != "" {
+		return nil, nil
+	}
+
+	events, err := t.translate(evt)
+	return t.limitTokens(events), err
+}
 
-		// Skip header
-		header := make([]byte, headerLen)
-		if _, err := io.ReadFull(r, header); err != nil {
-			break
+func (t *Translator) translate(evt any) ([]SSEEvent, error) {
+	switch e := evt.(type) {
+	case *AssistantResponseEvent:
+		if e.Content == "" {
+			return nil, nil
 		}
+		return t.translateText(e.Content), nil
 
-		payloadLen := int(totalLen) - int(headerLen) - 12
-		payload := make([]byte, payloadLen)
-		if _, err := io.ReadFull(r, payload); err != nil {
-			break
+	case *ReasoningContentEvent:
+		if t.DropThinking {
+			return nil, nil
 		}
+		return t.translateReasoning(e), nil
+
+	case *ToolUseEvent:
+		return t.translateToolUse(e), nil
 
-		// Skip CRC32
-		if _, err := r.Seek(4, io.SeekCurrent); err != nil {
-			break
+	case *MessageMetadataEvent:
+		t.ConversationId = e.ConversationId
+		t.UtteranceId = e.UtteranceId
+
+	case *MetadataEvent:
+		if e.TokenUsage != nil {
+			t.Usage = e
 		}
 
-		payloadStr := strings.TrimPrefix(string(payload), "vent")
-
-		var evt assistantResponseEvent
-		if err := json.Unmarshal([]byte(payloadStr), &evt); err == nil {
-
-			events = append(events, convertAssistantEventToSSE(evt))
-
-			if evt.ToolUseId != "" && evt.Name != "" {
-				if evt.Stop {
-					events = append(events, SSEEvent{
-						Event: "message_delta",
-						Data: map[string]interface{}{
-							"type": "message_delta",
-							"delta": map[string]interface{}{
-								"stop_reason":   "tool_use",
-								"stop_sequence": nil,
-							},
-							"usage": map[string]interface{}{"output_tokens": 0},
-						},
-					})
-				}
+	case *MeteringEvent:
+		if t.Metering == nil {
+			t.Metering = &MeteringEvent{Unit: e.Unit, UnitPlural: e.UnitPlural}
+		}
+		t.Metering.Usage += e.Usage
 
-			}
-		} else {
-			log.Println("json unmarshal error:", err)
+	case *ContextUsageEvent:
+		t.ContextUsagePercentage = e.ContextUsagePercentage
+
+	case *InvalidStateEvent:
+		return nil, &UpstreamError{ExceptionType: "InvalidStateException", Message: e.Message}
+
+	case *CodeReferenceEvent, *FollowupPromptEvent, *SupplementaryWebLinksEvent:
+		// No Anthropic equivalent; dropped on purpose.
+
+	case *UnknownEvent:
+		log.Printf("ignoring unknown event type %q", e.Type)
+	}
+	return nil, nil
+}
+
+// TokenUsage returns the token counts reported by the upstream, if any.
+func (t *Translator) TokenUsage() (inputTokens, outputTokens int, ok bool) {
+	if t.Usage == nil || t.Usage.TokenUsage == nil {
+		return 0, 0, false
+	}
+	u := t.Usage.TokenUsage
+	return u.UncachedInputTokens + u.CacheReadInputTokens + u.CacheWriteInputTokens, u.OutputTokens, true
+}
+
+// Metadata returns the response metadata worth passing on to the client,
+// or nil when the upstream sent none.
+func (t *Translator) Metadata() map[string]interface{} {
+	metadata := map[string]interface{}{}
+	if t.ConversationId != "" {
+		metadata["conversation_id"] = t.ConversationId
+	}
+	if t.Metering != nil {
+		metadata["metering"] = map[string]interface{}{
+			"unit":  t.Metering.UnitPlural,
+			"usage": t.Metering.Usage,
 		}
 	}
+	if t.ContextUsagePercentage > 0 {
+		metadata["context_usage_percentage"] = t.ContextUsagePercentage
+	}
+	if len(metadata) == 0 {
+		return nil
+	}
+	return metadata
+}
 
+// Finish closes the content block that is still open, if any.
+func (t *Translator) Finish() []SSEEvent {
+	return t.limitTokens(t.closeBlock())
+}
+
+// StopReason returns "stop_sequence" or "max_tokens" when the proxy ended
+// the response early, "tool_use" when it ended with a tool call and
+// "end_turn" otherwise.
+func (t *Translator) StopReason() string {
+	if t.stopReason != "" {
+		return t.stopReason
+	}
+	if t.lastBlockType == "tool_use" {
+		return "tool_use"
+	}
+	return "end_turn"
+}
+
+// StopSequence returns the stop sequence that ended the response, or "".
+func (t *Translator) StopSequence() string {
+	return t.stopSequence
+}
+
+func (t *Translator) translateText(text string) []SSEEvent {
+	var events []SSEEvent
+	if t.open == nil || t.open.blockType != "text" {
+		events = append(events, t.closeBlock()...)
+		events = append(events, t.startBlock("text", "", map[string]interface{}{
+			"type": "text",
+			"text": "",
+		}))
+	}
+
+	if len(t.StopSequences) > 0 {
+		return append(events, t.scanStopSequences(text)...)
+	}
+	return append(events, t.textDelta(text))
+}
+
+func (t *Translator) textDelta(text string) SSEEvent {
+	return t.delta(map[string]interface{}{
+		"type": "text_delta",
+		"text": text,
+	})
+}
+
+func (t *Translator) translateReasoning(evt *ReasoningContentEvent) []SSEEvent {
+	var events []SSEEvent
+
+	if len(evt.RedactedContent) > 0 {
+		events = append(events, t.closeBlock()...)
+		events = append(events, t.startBlock("redacted_thinking", "", map[string]interface{}{
+			"type": "redacted_thinking",
+			"data": base64.StdEncoding.EncodeToString(evt.RedactedContent),
+		}))
+		return append(events, t.closeBlock()...)
+	}
+
+	if evt.Text == "" && evt.Signature == "" {
+		return nil
+	}
+
+	if t.open == nil || t.open.blockType != "thinking" {
+		events = append(events, t.closeBlock()...)
+		events = append(events, t.startBlock("thinking", "", map[string]interface{}{
+			"type":     "thinking",
+			"thinking": "",
+		}))
+	}
+
+	if evt.Text != "" {
+		events = append(events, t.delta(map[string]interface{}{
+			"type":     "thinking_delta",
+			"thinking": evt.Text,
+		}))
+	}
+
+	// The signature seals the reasoning block; anything after it starts a new one.
+	if evt.Signature != "" {
+		events = append(events, t.delta(map[string]interface{}{
+			"type":      "signature_delta",
+			"signature": evt.Signature,
+		}))
+		events = append(events, t.closeBlock()...)
+	}
 	return events
 }
 
-func convertAssistantEventToSSE(evt assistantResponseEvent) SSEEvent {
-	if evt.Content != "" {
-		return SSEEvent{
+func (t *Translator) delta(delta map[string]interface{}) SSEEvent {
+	return SSEEvent{
+		Event: "content_block_delta",
+		Data: map[string]interface{}{
+			"type":  "content_block_delta",
+			"index": t.open.index,
+			"delta": delta,
+		},
+	}
+}
+
+func (t *Translator) translateToolUse(evt *ToolUseEvent) []SSEEvent {
+	if evt.ToolUseId == "" || evt.Name == "" {
+		return nil
+	}
+
+	if !t.acceptToolUse(evt) {
+		return nil
+	}
+
+	var events []SSEEvent
+	if t.open == nil || t.open.toolUseId != evt.ToolUseId {
+		if evt.Stop && evt.Input == nil {
+			// Stop for a tool call that is no longer open
+			return nil
+		}
+		events = append(events, t.closeBlock()...)
+		events = append(events, t.startBlock("tool_use", evt.ToolUseId, map[string]interface{}{
+			"type":  "tool_use",
+			"id":    evt.ToolUseId,
+			"name":  evt.Name,
+			"input": map[string]interface{}{},
+		}))
+	}
+
+	if evt.Input != nil && *evt.Input != "" {
+		events = append(events, SSEEvent{
 			Event: "content_block_delta",
 			Data: map[string]interface{}{
 				"type":  "content_block_delta",
-				"index": 0,
+				"index": t.open.index,
 				"delta": map[string]interface{}{
-					"type": "text_delta",
-					"text": evt.Content,
+					"type":         "input_json_delta",
+					"partial_json": *evt.Input,
 				},
 			},
-		}
-	} else if evt.ToolUseId != "" && evt.Name != "" && !evt.Stop {
-
-		if evt.Input == nil {
-			return SSEEvent{
-				Event: "content_block_start",
-				Data: map[string]interface{}{
-					"type":  "content_block_start",
-					"index": 1,
-					"content_block": map[string]interface{}{
-						"type":  "tool_use",
-						"id":    evt.ToolUseId,
-						"name":  evt.Name,
-						"input": map[string]interface{}{},
-					},
-				},
-			}
-		} else {
-			return SSEEvent{
-				Event: "content_block_delta",
-				Data: map[string]interface{}{
-					"type":  "content_block_delta",
-					"index": 1,
-					"delta": map[string]interface{}{
-						"type":         "input_json_delta",
-						"id":           evt.ToolUseId,
-						"name":         evt.Name,
-						"partial_json": evt.Input,
-					},
-				},
-			}
-		}
+		})
+	}
 
-	} else if evt.Stop {
-		return SSEEvent{
-			Event: "content_block_stop",
-			Data: map[string]interface{}{
-				"type":  "content_block_stop",
-				"index": 1,
-			},
-		}
+	if evt.Stop {
+		events = append(events, t.closeBlock()...)
+	}
+	return events
+}
+
+func (t *Translator) startBlock(blockType, toolUseId string, block map[string]interface{}) SSEEvent {
+	t.open = &contentBlock{index: t.nextIndex, blockType: blockType, toolUseId: toolUseId}
+	t.nextIndex++
+
+	return SSEEvent{
+		Event: "content_block_start",
+		Data: map[string]interface{}{
+			"type":          "content_block_start",
+			"index":         t.open.index,
+			"content_block": block,
+		},
 	}
+}
+
+func (t *Translator) closeBlock() []SSEEvent {
+	if t.open == nil {
+		return nil
+	}
+
+	var events []SSEEvent
+	if t.pendingText != "" {
+		// No stop sequence can span two blocks; release what was held back.
+		events = append(events, t.textDelta(t.pendingText))
+		t.pendingText = ""
+	}
+
+	index := t.open.index
+	t.lastBlockType = t.open.blockType
+	t.open = nil
 
-	return SSEEvent{}
+	return append(events, SSEEvent{
+		Event: "content_block_stop",
+		Data: map[string]interface{}{
+			"type":  "content_block_stop",
+			"index": index,
+		},
+	})
 }
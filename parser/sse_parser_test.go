@@ -1,24 +1,201 @@
 package parser
 
 import (
+	"bytes"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"os"
+	"path/filepath"
 	"testing"
 )
 
-func TestParseCodeWhispererEvents(t *testing.T) {
-	data, err := os.ReadFile("response.raw")
+var update = flag.Bool("update", false, "rewrite the testdata fixtures and golden files")
+
+func strPtr(s string) *string { return &s }
+
+// fixtures scripts the synthetic CodeWhisperer responses under testdata.
+// Each one is written to <name>.raw and its translation to <name>.golden.
+var fixtures = []struct {
+	name     string
+	script   func(enc *Encoder)
+	truncate int // bytes cut off the end of the encoded stream
+}{
+	{
+		name: "text",
+		script: func(enc *Encoder) {
+			enc.EncodeEvent(EventMessageMetadata, MessageMetadataEvent{ConversationId: "conv-1"})
+			enc.EncodeEvent(EventAssistantResponse, AssistantResponseEvent{Content: "Hello"})
+			enc.EncodeEvent(EventAssistantResponse, AssistantResponseEvent{Content: ", world!"})
+			enc.EncodeEvent(EventMetering, MeteringEvent{Unit: "credit", UnitPlural: "credits", Usage: 0.25})
+			enc.EncodeEvent(EventFollowupPrompt, FollowupPromptEvent{})
+		},
+	},
+	{
+		name: "single_tool",
+		script: func(enc *Encoder) {
+			enc.EncodeEvent(EventAssistantResponse, AssistantResponseEvent{Content: "Reading the file."})
+			enc.EncodeEvent(EventToolUse, ToolUseEvent{Name: "Read", ToolUseId: "tooluse_1"})
+			enc.EncodeEvent(EventToolUse, ToolUseEvent{Name: "Read", ToolUseId: "tooluse_1", Input: strPtr(`{"path":`)})
+			enc.EncodeEvent(EventToolUse, ToolUseEvent{Name: "Read", ToolUseId: "tooluse_1", Input: strPtr(`"main.go"}`)})
+			enc.EncodeEvent(EventToolUse, ToolUseEvent{Name: "Read", ToolUseId: "tooluse_1", Stop: true})
+		},
+	},
+	{
+		name: "parallel_tools",
+		script: func(enc *Encoder) {
+			enc.EncodeEvent(EventToolUse, ToolUseEvent{Name: "Read", ToolUseId: "tooluse_1"})
+			enc.EncodeEvent(EventToolUse, ToolUseEvent{Name: "Read", ToolUseId: "tooluse_1", Input: strPtr(`{"path":"a.go"}`)})
+			enc.EncodeEvent(EventToolUse, ToolUseEvent{Name: "Read", ToolUseId: "tooluse_1", Stop: true})
+			enc.EncodeEvent(EventToolUse, ToolUseEvent{Name: "Grep", ToolUseId: "tooluse_2"})
+			enc.EncodeEvent(EventToolUse, ToolUseEvent{Name: "Grep", ToolUseId: "tooluse_2", Input: strPtr(`{"pattern":"TODO"}`)})
+			enc.EncodeEvent(EventToolUse, ToolUseEvent{Name: "Grep", ToolUseId: "tooluse_2", Stop: true})
+		},
+	},
+	{
+		name: "text_after_tool",
+		script: func(enc *Encoder) {
+			enc.EncodeEvent(EventToolUse, ToolUseEvent{Name: "Bash", ToolUseId: "tooluse_1", Input: strPtr(`{"command":"ls"}`)})
+			enc.EncodeEvent(EventToolUse, ToolUseEvent{Name: "Bash", ToolUseId: "tooluse_1", Stop: true})
+			enc.EncodeEvent(EventAssistantResponse, AssistantResponseEvent{Content: "Done."})
+		},
+	},
+	{
+		name: "thinking",
+		script: func(enc *Encoder) {
+			enc.EncodeEvent(EventReasoningContent, ReasoningContentEvent{Text: "The user wants "})
+			enc.EncodeEvent(EventReasoningContent, ReasoningContentEvent{Text: "a greeting."})
+			enc.EncodeEvent(EventReasoningContent, ReasoningContentEvent{Signature: "sig-1"})
+			enc.EncodeEvent(EventAssistantResponse, AssistantResponseEvent{Content: "Hello!"})
+		},
+	},
+	{
+		name: "redacted_thinking",
+		script: func(enc *Encoder) {
+			enc.EncodeEvent(EventReasoningContent, ReasoningContentEvent{RedactedContent: []byte("opaque")})
+			enc.EncodeEvent(EventToolUse, ToolUseEvent{Name: "Read", ToolUseId: "tooluse_1", Input: strPtr(`{}`), Stop: true})
+		},
+	},
+	{
+		name: "exception",
+		script: func(enc *Encoder) {
+			enc.EncodeEvent(EventAssistantResponse, AssistantResponseEvent{Content: "Partial"})
+			enc.EncodeException("ThrottlingException", "Too many requests, please wait before trying again.")
+		},
+	},
+	{
+		name: "truncated",
+		script: func(enc *Encoder) {
+			enc.EncodeEvent(EventAssistantResponse, AssistantResponseEvent{Content: "Cut"})
+			enc.EncodeEvent(EventAssistantResponse, AssistantResponseEvent{Content: " off"})
+		},
+		truncate: 10,
+	},
+	{
+		name: "unknown_event",
+		script: func(enc *Encoder) {
+			enc.EncodeEvent("brandNewEvent", map[string]string{"foo": "bar"})
+			enc.EncodeEvent(EventAssistantResponse, AssistantResponseEvent{Content: "Still here"})
+		},
+	},
+}
+
+// renderSSE formats events the way the server writes them to the client.
+func renderSSE(events []SSEEvent, err error) []byte {
+	var buf bytes.Buffer
+	for _, e := range events {
+		data, _ := json.Marshal(e.Data)
+		fmt.Fprintf(&buf, "event: %s\ndata: %s\n\n", e.Event, data)
+	}
 	if err != nil {
-		panic(err)
+		fmt.Fprintf(&buf, "error: %v\n", err)
+	}
+	return buf.Bytes()
+}
+
+func TestParseFixtures(t *testing.T) {
+	for _, tc := range fixtures {
+		t.Run(tc.name, func(t *testing.T) {
+			rawPath := filepath.Join("testdata", tc.name+".raw")
+			goldenPath := filepath.Join("testdata", tc.name+".golden")
+
+			if *update {
+				var buf bytes.Buffer
+				tc.script(NewEncoder(&buf))
+				raw := buf.Bytes()[:buf.Len()-tc.truncate]
+				if err := os.WriteFile(rawPath, raw, 0644); err != nil {
+					t.Fatal(err)
+				}
+				events, err := ParseResponse(raw)
+				if err := os.WriteFile(goldenPath, renderSSE(events, err), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			raw, err := os.ReadFile(rawPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want, err := os.ReadFile(goldenPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			events, err := ParseResponse(raw)
+			if got := renderSSE(events, err); !bytes.Equal(got, want) {
+				t.Errorf("SSE output mismatch\n--- got\n%s\n--- want\n%s", got, want)
+			}
+		})
 	}
+}
 
-	events := ParseEvents(data)
+func TestTranslatorDropThinking(t *testing.T) {
+	raw, err := os.ReadFile(filepath.Join("testdata", "thinking.raw"))
+	if err != nil {
+		t.Fatal(err)
+	}
 
+	tr := NewTranslator()
+	tr.DropThinking = true
+	events, err := tr.ParseResponse(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
 	for _, e := range events {
-		fmt.Printf("event: %s\n", e.Event)
-		json, _ := json.Marshal(e.Data)
+		data, _ := json.Marshal(e.Data)
+		if bytes.Contains(data, []byte("thinking")) || bytes.Contains(data, []byte("signature")) {
+			t.Errorf("unexpected reasoning output: %s", data)
+		}
+	}
+	if first := events[0].Data.(map[string]interface{}); first["index"] != 0 {
+		t.Errorf("text block index = %v, want 0", first["index"])
+	}
+}
+
+func TestTranslatorStopReason(t *testing.T) {
+	tests := []struct {
+		fixture string
+		want    string
+	}{
+		{"text", "end_turn"},
+		{"single_tool", "tool_use"},
+		{"parallel_tools", "tool_use"},
+		{"text_after_tool", "end_turn"},
+		{"thinking", "end_turn"},
+		{"redacted_thinking", "tool_use"},
+	}
 
-		fmt.Printf("data: %s\n\n", string(json))
+	for _, tt := range tests {
+		raw, err := os.ReadFile(filepath.Join("testdata", tt.fixture+".raw"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		tr := NewTranslator()
+		if _, err := tr.ParseResponse(raw); err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.fixture, err)
+		}
+		if got := tr.StopReason(); got != tt.want {
+			t.Errorf("%s: StopReason() = %q, want %q", tt.fixture, got, tt.want)
+		}
 	}
 }
@@ -1,13 +1,9 @@
 package main
 
 import (
-	"bytes"
 	"crypto/md5"
 	"encoding/hex"
 	"encoding/json"
-	"fmt"
-	"io"
-	"net/http"
 	"sort"
 	"strings"
 	"sync"
@@ -331,48 +327,10 @@ func (pc *PredictiveCache) prefetchWorker() {
 
 // executePrefetch 执行预取请求
 func (pc *PredictiveCache) executePrefetch(req AnthropicRequest) (interface{}, error) {
-	// 获取token
-	token, err := tokenManager.GetToken()
+	respBody, err := upstreamClient.Call(req)
 	if err != nil {
 		return nil, err
 	}
-
-	// 构建请求
-	cwReq := buildCodeWhispererRequest(req)
-	cwReqBody, err := json.Marshal(cwReq)
-	if err != nil {
-		return nil, err
-	}
-
-	// 发送请求
-	httpReq, err := http.NewRequest(
-		http.MethodPost,
-		config.API.CodeWhispererURL,
-		bytes.NewBuffer(cwReqBody),
-	)
-	if err != nil {
-		return nil, err
-	}
-
-	httpReq.Header.Set("Authorization", "Bearer "+token.AccessToken)
-	httpReq.Header.Set("Content-Type", "application/json")
-
-	client := httpClientManager.GetClient()
-	resp, err := client.Do(httpReq)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("预取请求失败: %d", resp.StatusCode)
-	}
-
-	respBody, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
-
 	return respBody, nil
 }
 
@@ -1,13 +1,9 @@
 package main
 
 import (
-	"bytes"
 	"crypto/md5"
 	"encoding/hex"
 	"encoding/json"
-	"fmt"
-	"io"
-	"net/http"
 	"sync"
 	"time"
 )
@@ -154,58 +150,9 @@ func (rb *RequestBatcher) executeBatch(batch map[string]*BatchedRequest) {
 
 // executeRequest 执行单个请求
 func (rb *RequestBatcher) executeRequest(req AnthropicRequest) (interface{}, error) {
-	// 获取token
-	token, err := tokenManager.GetToken()
+	respBody, err := upstreamClient.Call(req)
 	if err != nil {
 		return nil, err
 	}
-
-	// 构建CodeWhisperer请求
-	cwReq := buildCodeWhispererRequest(req)
-	
-	// 序列化请求体
-	cwReqBody, err := json.Marshal(cwReq)
-	if err != nil {
-		return nil, err
-	}
-
-	// 创建HTTP请求
-	httpReq, err := http.NewRequest(
-		http.MethodPost,
-		"https://codewhisperer.us-east-1.amazonaws.com/generateAssistantResponse",
-		bytes.NewBuffer(cwReqBody),
-	)
-	if err != nil {
-		return nil, err
-	}
-
-	// 设置请求头
-	httpReq.Header.Set("Authorization", "Bearer "+token.AccessToken)
-	httpReq.Header.Set("Content-Type", "application/json")
-
-	// 发送请求
-	client := httpClientManager.GetClient()
-	resp, err := client.Do(httpReq)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	// 处理响应
-	respBody, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
-
-	// 如果token过期，异步刷新
-	if resp.StatusCode == 403 {
-		go tokenManager.refreshTokenAsync()
-		return nil, fmt.Errorf("token已过期，已异步刷新，请重试")
-	}
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("API请求失败，状态码: %d", resp.StatusCode)
-	}
-
 	return respBody, nil
 }
@@ -304,48 +304,10 @@ func (rd *RequestDeduplicator) executeRequest(activeReq *ActiveRequest) {
 
 // performAPIRequest 执行API请求
 func (rd *RequestDeduplicator) performAPIRequest(req AnthropicRequest) (interface{}, error) {
-	// 获取token
-	token, err := tokenManager.GetToken()
+	respBody, err := upstreamClient.Call(req)
 	if err != nil {
 		return nil, err
 	}
-
-	// 构建请求
-	cwReq := buildCodeWhispererRequest(req)
-	cwReqBody, err := json.Marshal(cwReq)
-	if err != nil {
-		return nil, err
-	}
-
-	// 发送请求
-	httpReq, err := http.NewRequest(
-		http.MethodPost,
-		config.API.CodeWhispererURL,
-		bytes.NewBuffer(cwReqBody),
-	)
-	if err != nil {
-		return nil, err
-	}
-
-	httpReq.Header.Set("Authorization", "Bearer "+token.AccessToken)
-	httpReq.Header.Set("Content-Type", "application/json")
-
-	client := httpClientManager.GetClient()
-	resp, err := client.Do(httpReq)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("API请求失败: %d", resp.StatusCode)
-	}
-
-	respBody, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
-
 	return respBody, nil
 }
 
@@ -385,9 +347,13 @@ func (rd *RequestDeduplicator) generateMergeKey(req AnthropicRequest) string {
 		Model        string `json:"model"`
 		MessageCount int    `json:"message_count"`
 		LastUserMsg  string `json:"last_user_msg"`
+		LastImages   string `json:"last_images,omitempty"`
+		// tool_choice 会改变发给上游的工具列表和指令
+		ToolChoice *AnthropicToolChoice `json:"tool_choice,omitempty"`
 	}{
 		Model:        req.Model,
 		MessageCount: len(req.Messages),
+		ToolChoice:   req.ToolChoice,
 	}
 
 	// 提取最后一条用户消息的前100个字符
@@ -398,6 +364,8 @@ func (rd *RequestDeduplicator) generateMergeKey(req AnthropicRequest) string {
 				content = content[:100]
 			}
 			key.LastUserMsg = content
+			// 图片不同的请求不能合并
+			key.LastImages = imageFingerprint(req.Messages[i].Content)
 			break
 		}
 	}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// tool_choice 的取值
+const (
+	ToolChoiceAuto = "auto" // 由模型决定是否调用工具
+	ToolChoiceAny  = "any"  // 必须调用某个工具
+	ToolChoiceTool = "tool" // 必须调用 name 指定的工具
+	ToolChoiceNone = "none" // 不允许调用工具
+)
+
+// AnthropicToolChoice 表示请求中的 tool_choice 配置
+type AnthropicToolChoice struct {
+	Type                   string `json:"type"`
+	Name                   string `json:"name,omitempty"`
+	DisableParallelToolUse *bool  `json:"disable_parallel_tool_use,omitempty"`
+}
+
+// validateRequestParams 校验 max_tokens、采样参数、stop_sequences 和 tool_choice。
+// CodeWhisperer 没有采样参数，temperature、top_p、top_k 只做校验不转发
+func validateRequestParams(req AnthropicRequest) error {
+	if req.MaxTokens < 0 {
+		return &invalidRequestError{"max_tokens: must not be negative"}
+	}
+	if req.Temperature != nil && (*req.Temperature < 0 || *req.Temperature > 1) {
+		return &invalidRequestError{"temperature: must be between 0 and 1"}
+	}
+	if req.TopP != nil && (*req.TopP < 0 || *req.TopP > 1) {
+		return &invalidRequestError{"top_p: must be between 0 and 1"}
+	}
+	if req.TopK != nil && *req.TopK < 0 {
+		return &invalidRequestError{"top_k: must be greater than or equal to 0"}
+	}
+	for i, seq := range req.StopSequences {
+		if seq == "" {
+			return &invalidRequestError{fmt.Sprintf("stop_sequences.%d: must not be empty", i)}
+		}
+	}
+
+	choice := req.ToolChoice
+	if choice == nil {
+		return nil
+	}
+	switch choice.Type {
+	case ToolChoiceAuto, ToolChoiceNone:
+		return nil
+	case ToolChoiceAny, ToolChoiceTool:
+	default:
+		return &invalidRequestError{fmt.Sprintf("tool_choice.type: unexpected value %q, expected auto, any, tool or none", choice.Type)}
+	}
+
+	if len(req.Tools) == 0 {
+		return &invalidRequestError{fmt.Sprintf("tool_choice: type %q requires tools", choice.Type)}
+	}
+	if thinkingEnabled(req) {
+		return &invalidRequestError{fmt.Sprintf("tool_choice: type %q is not supported with extended thinking", choice.Type)}
+	}
+	if choice.Type == ToolChoiceTool {
+		if choice.Name == "" {
+			return &invalidRequestError{"tool_choice.name: required for type tool"}
+		}
+		if findTool(req.Tools, choice.Name) == nil {
+			return &invalidRequestError{fmt.Sprintf("tool_choice.name: tool %q not found in tools", choice.Name)}
+		}
+	}
+	return nil
+}
+
+// findTool 按名称查找工具
+func findTool(tools []AnthropicTool, name string) *AnthropicTool {
+	for i := range tools {
+		if tools[i].Name == name {
+			return &tools[i]
+		}
+	}
+	return nil
+}
+
+// requestTools 返回按 tool_choice 过滤后实际发给上游的工具：none 不发送，tool 只发送指定的工具
+func requestTools(req AnthropicRequest) []AnthropicTool {
+	if req.ToolChoice == nil {
+		return req.Tools
+	}
+	switch req.ToolChoice.Type {
+	case ToolChoiceNone:
+		return nil
+	case ToolChoiceTool:
+		if tool := findTool(req.Tools, req.ToolChoice.Name); tool != nil {
+			return []AnthropicTool{*tool}
+		}
+	}
+	return req.Tools
+}
+
+// toolChoicePrompt 返回强制调用工具时追加到当前 user 消息末尾的指令，
+// CodeWhisperer 没有 tool_choice 字段，只能通过提示词要求
+func toolChoicePrompt(req AnthropicRequest) string {
+	if req.ToolChoice == nil {
+		return ""
+	}
+	switch req.ToolChoice.Type {
+	case ToolChoiceAny:
+		return "You must respond by calling one of the available tools. Do not reply with text only."
+	case ToolChoiceTool:
+		return fmt.Sprintf("You must respond by calling the %s tool. Do not reply with text only.", req.ToolChoice.Name)
+	}
+	return ""
+}
+
+// allowTool 返回转换响应时判断工具调用是否放行的函数，不需要过滤时返回 nil
+func allowTool(req AnthropicRequest) func(name string) bool {
+	if req.ToolChoice == nil || req.ToolChoice.Type == ToolChoiceAuto || req.ToolChoice.Type == ToolChoiceAny {
+		return nil
+	}
+	allowed := map[string]bool{}
+	for _, tool := range requestTools(req) {
+		allowed[tool.Name] = true
+	}
+	return func(name string) bool {
+		return allowed[name]
+	}
+}
+
+// maxToolUses 返回一次响应允许的工具调用数，disable_parallel_tool_use 时为 1，0 表示不限制
+func maxToolUses(req AnthropicRequest) int {
+	if req.ToolChoice != nil && req.ToolChoice.DisableParallelToolUse != nil && *req.ToolChoice.DisableParallelToolUse {
+		return 1
+	}
+	return 0
+}
+
+// checkToolChoice 校验响应是否满足 tool_choice：any 和 tool 要求响应以工具调用结束
+func checkToolChoice(req AnthropicRequest, stopReason string) error {
+	if req.ToolChoice == nil || stopReason == "tool_use" {
+		return nil
+	}
+	switch req.ToolChoice.Type {
+	case ToolChoiceAny, ToolChoiceTool:
+		return fmt.Errorf("模型未按 tool_choice %s 调用工具 (stop_reason: %s)", req.ToolChoice.Type, stopReason)
+	}
+	return nil
+}
+
+// stopSequenceValue 返回响应中 stop_sequence 字段的值，未命中时为 null
+func stopSequenceValue(stopSequence string) any {
+	if stopSequence == "" {
+		return nil
+	}
+	return stopSequence
+}
+
+// jsonResponseInstruction 要求只以 JSON 回复的指令，schema 为空时只要求合法 JSON
+func jsonResponseInstruction(schema map[string]any) string {
+	if schema == nil {
+		return "Respond only with a valid JSON value, without any surrounding text or code fences."
+	}
+	data, _ := json.Marshal(schema)
+	return fmt.Sprintf("Respond only with JSON that matches this JSON schema, without any surrounding text or code fences:\n%s", data)
+}
+
+// appendInstruction 把指令追加到消息内容末尾
+func appendInstruction(content, instruction string) string {
+	switch {
+	case instruction == "":
+		return content
+	case content == "":
+		return instruction
+	default:
+		return content + "\n\n" + instruction
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestValidateRequestParams(t *testing.T) {
+	f := func(v float64) *float64 { return &v }
+	k := func(v int) *int { return &v }
+	tools := []AnthropicTool{{Name: "Read"}, {Name: "Bash"}}
+
+	tests := []struct {
+		name    string
+		req     AnthropicRequest
+		wantErr bool
+	}{
+		{"defaults", AnthropicRequest{}, false},
+		{"negative max_tokens", AnthropicRequest{MaxTokens: -1}, true},
+		{"sampling in range", AnthropicRequest{Temperature: f(0.7), TopP: f(0.9), TopK: k(40)}, false},
+		{"temperature too high", AnthropicRequest{Temperature: f(1.5)}, true},
+		{"negative top_p", AnthropicRequest{TopP: f(-0.1)}, true},
+		{"negative top_k", AnthropicRequest{TopK: k(-1)}, true},
+		{"empty stop sequence", AnthropicRequest{StopSequences: []string{"END", ""}}, true},
+		{"tool_choice auto without tools", AnthropicRequest{ToolChoice: &AnthropicToolChoice{Type: ToolChoiceAuto}}, false},
+		{"tool_choice none", AnthropicRequest{Tools: tools, ToolChoice: &AnthropicToolChoice{Type: ToolChoiceNone}}, false},
+		{"tool_choice any", AnthropicRequest{Tools: tools, ToolChoice: &AnthropicToolChoice{Type: ToolChoiceAny}}, false},
+		{"tool_choice any without tools", AnthropicRequest{ToolChoice: &AnthropicToolChoice{Type: ToolChoiceAny}}, true},
+		{"tool_choice tool", AnthropicRequest{Tools: tools, ToolChoice: &AnthropicToolChoice{Type: ToolChoiceTool, Name: "Bash"}}, false},
+		{"tool_choice tool without name", AnthropicRequest{Tools: tools, ToolChoice: &AnthropicToolChoice{Type: ToolChoiceTool}}, true},
+		{"tool_choice unknown tool", AnthropicRequest{Tools: tools, ToolChoice: &AnthropicToolChoice{Type: ToolChoiceTool, Name: "Write"}}, true},
+		{"tool_choice unknown type", AnthropicRequest{Tools: tools, ToolChoice: &AnthropicToolChoice{Type: "required"}}, true},
+		{"tool_choice any with thinking", AnthropicRequest{
+			Model:      "claude-sonnet-4-20250514",
+			MaxTokens:  4096,
+			Thinking:   &AnthropicThinking{Type: "enabled", BudgetTokens: 2048},
+			Tools:      tools,
+			ToolChoice: &AnthropicToolChoice{Type: ToolChoiceAny},
+		}, true},
+	}
+
+	for _, tt := range tests {
+		err := validateRequestParams(tt.req)
+		if !tt.wantErr {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", tt.name, err)
+			}
+			continue
+		}
+		var reqErr *invalidRequestError
+		if !errors.As(err, &reqErr) {
+			t.Errorf("%s: got %v, want *invalidRequestError", tt.name, err)
+		}
+	}
+}
+
+func TestToolChoiceRequest(t *testing.T) {
+	tools := []AnthropicTool{{Name: "Read"}, {Name: "Bash"}}
+	messages := []AnthropicRequestMessage{{Role: "user", Content: "list files"}}
+
+	tests := []struct {
+		choice      *AnthropicToolChoice
+		wantTools   []string
+		wantContent string
+	}{
+		{nil, []string{"Read", "Bash"}, "list files"},
+		{&AnthropicToolChoice{Type: ToolChoiceAuto}, []string{"Read", "Bash"}, "list files"},
+		{&AnthropicToolChoice{Type: ToolChoiceNone}, nil, "list files"},
+		{&AnthropicToolChoice{Type: ToolChoiceAny}, []string{"Read", "Bash"}, "list files\n\n" + toolChoicePrompt(AnthropicRequest{ToolChoice: &AnthropicToolChoice{Type: ToolChoiceAny}})},
+		{&AnthropicToolChoice{Type: ToolChoiceTool, Name: "Bash"}, []string{"Bash"}, "list files\n\nYou must respond by calling the Bash tool. Do not reply with text only."},
+	}
+
+	for _, tt := range tests {
+		cwReq, err := buildCodeWhispererRequest(AnthropicRequest{Messages: messages, Tools: tools, ToolChoice: tt.choice})
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg := cwReq.ConversationState.CurrentMessage.UserInputMessage
+
+		var names []string
+		for _, tool := range msg.UserInputMessageContext.Tools {
+			names = append(names, tool.ToolSpecification.Name)
+		}
+		if len(names) != len(tt.wantTools) {
+			t.Errorf("%+v: tools = %v, want %v", tt.choice, names, tt.wantTools)
+		} else {
+			for i := range names {
+				if names[i] != tt.wantTools[i] {
+					t.Errorf("%+v: tools = %v, want %v", tt.choice, names, tt.wantTools)
+					break
+				}
+			}
+		}
+		if msg.Content != tt.wantContent {
+			t.Errorf("%+v: content = %q, want %q", tt.choice, msg.Content, tt.wantContent)
+		}
+	}
+}
+
+func TestCheckToolChoice(t *testing.T) {
+	anyChoice := AnthropicRequest{ToolChoice: &AnthropicToolChoice{Type: ToolChoiceAny}}
+	if err := checkToolChoice(anyChoice, "end_turn"); err == nil {
+		t.Error("tool_choice any with a text-only response: expected an error")
+	}
+	if err := checkToolChoice(anyChoice, "tool_use"); err != nil {
+		t.Errorf("tool_choice any with a tool call: %v", err)
+	}
+	if err := checkToolChoice(AnthropicRequest{}, "end_turn"); err != nil {
+		t.Errorf("no tool_choice: %v", err)
+	}
+}
+
+func TestBuildAnthropicResponseLimits(t *testing.T) {
+	raw, err := os.ReadFile(filepath.Join("parser", "testdata", "text.raw"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name         string
+		req          AnthropicRequest
+		wantText     string
+		wantReason   string
+		wantSequence any
+	}{
+		{"unlimited", AnthropicRequest{MaxTokens: 1024}, "Hello, world!", "end_turn", nil},
+		{"max_tokens", AnthropicRequest{MaxTokens: 2}, "Hello,", "max_tokens", nil},
+		{"stop_sequence", AnthropicRequest{MaxTokens: 1024, StopSequences: []string{"world"}}, "Hello, ", "stop_sequence", "world"},
+	}
+
+	for _, tt := range tests {
+		tt.req.Messages = []AnthropicRequestMessage{{Role: "user", Content: "hi"}}
+		resp, err := buildAnthropicResponse(tt.req, raw)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		content := resp["content"].([]map[string]any)
+		if len(content) != 1 || content[0]["text"] != tt.wantText {
+			t.Errorf("%s: content = %v, want text %q", tt.name, content, tt.wantText)
+		}
+		if resp["stop_reason"] != tt.wantReason {
+			t.Errorf("%s: stop_reason = %v, want %s", tt.name, resp["stop_reason"], tt.wantReason)
+		}
+		if resp["stop_sequence"] != tt.wantSequence {
+			t.Errorf("%s: stop_sequence = %v, want %v", tt.name, resp["stop_sequence"], tt.wantSequence)
+		}
+	}
+}
@@ -0,0 +1,538 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bestk/kiro2cc/parser"
+)
+
+// ResponsesRequest 表示 OpenAI /v1/responses 的请求结构
+type ResponsesRequest struct {
+	Model              string          `json:"model"`
+	Input              any             `json:"input"` // string 或输入项数组
+	Instructions       string          `json:"instructions,omitempty"`
+	Tools              []ResponsesTool `json:"tools,omitempty"`
+	ToolChoice         any             `json:"tool_choice,omitempty"` // "none"、"auto"、"required" 或 {"type":"function","name":...}
+	ParallelToolCalls  *bool           `json:"parallel_tool_calls,omitempty"`
+	MaxOutputTokens    *int            `json:"max_output_tokens,omitempty"`
+	Temperature        *float64        `json:"temperature,omitempty"`
+	TopP               *float64        `json:"top_p,omitempty"`
+	Stream             bool            `json:"stream"`
+	Store              *bool           `json:"store,omitempty"` // 默认保存，供 previous_response_id 使用
+	PreviousResponseId string          `json:"previous_response_id,omitempty"`
+	Metadata           map[string]any  `json:"metadata,omitempty"`
+}
+
+// ResponsesTool 表示 Responses API 的工具定义，只支持 function 类型
+type ResponsesTool struct {
+	Type        string         `json:"type"`
+	Name        string         `json:"name"`
+	Description string         `json:"description,omitempty"`
+	Parameters  map[string]any `json:"parameters,omitempty"`
+}
+
+// ResponsesInputItem 表示 input 数组中的一项：消息、function_call 或 function_call_output
+type ResponsesInputItem struct {
+	Type      string `json:"type,omitempty"` // 省略时为 message
+	Role      string `json:"role,omitempty"`
+	Content   any    `json:"content,omitempty"`
+	CallId    string `json:"call_id,omitempty"`
+	Name      string `json:"name,omitempty"`
+	Arguments string `json:"arguments,omitempty"`
+	Output    any    `json:"output,omitempty"`
+}
+
+// toAnthropicRequest 把 Responses 请求转换为 AnthropicRequest，history 为 previous_response_id 对应的对话
+func (req ResponsesRequest) toAnthropicRequest(history []AnthropicRequestMessage) (AnthropicRequest, error) {
+	anthropicReq := AnthropicRequest{
+		Model:    req.Model,
+		Stream:   req.Stream,
+		TopP:     req.TopP,
+		Messages: append([]AnthropicRequestMessage(nil), history...),
+	}
+	if req.MaxOutputTokens != nil {
+		anthropicReq.MaxTokens = *req.MaxOutputTokens
+	}
+	if req.Temperature != nil {
+		// OpenAI 的取值范围是 0 到 2，Anthropic 是 0 到 1
+		temperature := *req.Temperature / 2
+		anthropicReq.Temperature = &temperature
+	}
+
+	for i, tool := range req.Tools {
+		if tool.Type != "function" {
+			return anthropicReq, &invalidRequestError{fmt.Sprintf("tools.%d.type: unsupported tool type %q, only function tools are supported", i, tool.Type)}
+		}
+		anthropicReq.Tools = append(anthropicReq.Tools, openAIFunctionTool(OpenAIFunction{Name: tool.Name, Description: tool.Description, Parameters: tool.Parameters}))
+	}
+	toolChoice, err := openAIToolChoice(req.ToolChoice, nil)
+	if err != nil {
+		return anthropicReq, err
+	}
+	if req.ParallelToolCalls != nil && !*req.ParallelToolCalls {
+		if toolChoice == nil {
+			toolChoice = &AnthropicToolChoice{Type: ToolChoiceAuto}
+		}
+		disable := true
+		toolChoice.DisableParallelToolUse = &disable
+	}
+	anthropicReq.ToolChoice = toolChoice
+
+	systemParts := []string{}
+	if req.Instructions != "" {
+		systemParts = append(systemParts, req.Instructions)
+	}
+
+	items, err := responsesInputItems(req.Input)
+	if err != nil {
+		return anthropicReq, err
+	}
+	for i, item := range items {
+		switch item.Type {
+		case "", "message":
+			switch item.Role {
+			case "system", "developer":
+				systemParts = append(systemParts, openAIContentText(item.Content))
+			case "user":
+				blocks, err := openAIContentBlocks(item.Content)
+				if err != nil {
+					return anthropicReq, fmt.Errorf("input.%d.%w", i, err)
+				}
+				anthropicReq.Messages = append(anthropicReq.Messages, AnthropicRequestMessage{Role: "user", Content: blocks})
+			case "assistant":
+				anthropicReq.Messages = append(anthropicReq.Messages, AnthropicRequestMessage{Role: "assistant", Content: []interface{}{
+					map[string]interface{}{"type": "text", "text": openAIContentText(item.Content)},
+				}})
+			default:
+				return anthropicReq, &invalidRequestError{fmt.Sprintf("input.%d.role: unexpected role %q", i, item.Role)}
+			}
+
+		case "function_call":
+			input := map[string]interface{}{}
+			if strings.TrimSpace(item.Arguments) != "" {
+				if err := json.Unmarshal([]byte(item.Arguments), &input); err != nil {
+					return anthropicReq, &invalidRequestError{fmt.Sprintf("input.%d.arguments: must be a JSON object", i)}
+				}
+			}
+			anthropicReq.Messages = append(anthropicReq.Messages, AnthropicRequestMessage{Role: "assistant", Content: []interface{}{
+				map[string]interface{}{"type": "tool_use", "id": item.CallId, "name": item.Name, "input": input},
+			}})
+
+		case "function_call_output":
+			output, ok := item.Output.(string)
+			if !ok {
+				output = openAIContentText(item.Output)
+			}
+			anthropicReq.Messages = append(anthropicReq.Messages, AnthropicRequestMessage{Role: "user", Content: []interface{}{
+				map[string]interface{}{"type": "tool_result", "tool_use_id": item.CallId, "content": output},
+			}})
+
+		case "reasoning":
+			// 推理内容无法转发给 CodeWhisperer，忽略
+
+		default:
+			return anthropicReq, &invalidRequestError{fmt.Sprintf("input.%d.type: unsupported input item type %q", i, item.Type)}
+		}
+	}
+	if text := strings.Join(systemParts, "\n\n"); text != "" {
+		anthropicReq.System = AnthropicSystem{{Type: "text", Text: text}}
+	}
+
+	return anthropicReq, nil
+}
+
+// responsesInputItems 解析 input，string 视为一条 user 消息
+func responsesInputItems(input any) ([]ResponsesInputItem, error) {
+	switch v := input.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return []ResponsesInputItem{{Type: "message", Role: "user", Content: v}}, nil
+	case []interface{}:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		var items []ResponsesInputItem
+		if err := json.Unmarshal(data, &items); err != nil {
+			return nil, &invalidRequestError{fmt.Sprintf("input: %v", err)}
+		}
+		return items, nil
+	}
+	return nil, &invalidRequestError{"input: must be a string or an array of input items"}
+}
+
+// responseID 生成带前缀的 Responses API 对象 id
+func responseID(prefix string) string {
+	return prefix + "_" + strings.ReplaceAll(generateUUID(), "-", "")
+}
+
+// responsesBuilder 把翻译后的 Anthropic 内容事件组装为 Responses API 的输出项，
+// emit 不为空时同时发出对应的流式事件
+type responsesBuilder struct {
+	emit   func(eventType string, data map[string]any)
+	output []map[string]any
+	items  map[int]*responsesItem // 按 Anthropic content block index 索引
+}
+
+// responsesItem 表示正在生成的一个输出项
+type responsesItem struct {
+	outputIndex int
+	item        map[string]any
+	text        strings.Builder
+}
+
+func newResponsesBuilder(emit func(eventType string, data map[string]any)) *responsesBuilder {
+	if emit == nil {
+		emit = func(string, map[string]any) {}
+	}
+	return &responsesBuilder{emit: emit, output: []map[string]any{}, items: map[int]*responsesItem{}}
+}
+
+// handle 处理一个 Anthropic 内容事件，thinking 块不产生输出项
+func (b *responsesBuilder) handle(e parser.SSEEvent) {
+	data, _ := e.Data.(map[string]interface{})
+	index, _ := data["index"].(int)
+
+	switch e.Event {
+	case "content_block_start":
+		block := data["content_block"].(map[string]interface{})
+		var item map[string]any
+		switch block["type"] {
+		case "text":
+			item = map[string]any{"type": "message", "id": responseID("msg"), "status": "in_progress", "role": "assistant", "content": []any{}}
+		case "tool_use":
+			item = map[string]any{"type": "function_call", "id": responseID("fc"), "call_id": block["id"], "name": block["name"], "arguments": "", "status": "in_progress"}
+		default:
+			return
+		}
+		it := &responsesItem{outputIndex: len(b.output), item: item}
+		b.items[index] = it
+		b.output = append(b.output, item)
+		b.emit("response.output_item.added", map[string]any{"output_index": it.outputIndex, "item": item})
+		if item["type"] == "message" {
+			b.emit("response.content_part.added", map[string]any{
+				"item_id": item["id"], "output_index": it.outputIndex, "content_index": 0,
+				"part": outputTextPart(""),
+			})
+		}
+
+	case "content_block_delta":
+		it, ok := b.items[index]
+		if !ok {
+			return
+		}
+		delta := data["delta"].(map[string]interface{})
+		switch delta["type"] {
+		case "text_delta":
+			text := delta["text"].(string)
+			it.text.WriteString(text)
+			b.emit("response.output_text.delta", map[string]any{
+				"item_id": it.item["id"], "output_index": it.outputIndex, "content_index": 0, "delta": text,
+			})
+		case "input_json_delta":
+			partial := delta["partial_json"].(string)
+			it.text.WriteString(partial)
+			b.emit("response.function_call_arguments.delta", map[string]any{
+				"item_id": it.item["id"], "output_index": it.outputIndex, "delta": partial,
+			})
+		}
+
+	case "content_block_stop":
+		it, ok := b.items[index]
+		if !ok {
+			return
+		}
+		delete(b.items, index)
+		it.item["status"] = "completed"
+		if it.item["type"] == "message" {
+			text := it.text.String()
+			b.emit("response.output_text.done", map[string]any{
+				"item_id": it.item["id"], "output_index": it.outputIndex, "content_index": 0, "text": text,
+			})
+			b.emit("response.content_part.done", map[string]any{
+				"item_id": it.item["id"], "output_index": it.outputIndex, "content_index": 0, "part": outputTextPart(text),
+			})
+			it.item["content"] = []any{outputTextPart(text)}
+		} else {
+			arguments := it.text.String()
+			if arguments == "" {
+				arguments = "{}"
+			}
+			it.item["arguments"] = arguments
+			b.emit("response.function_call_arguments.done", map[string]any{
+				"item_id": it.item["id"], "output_index": it.outputIndex, "arguments": arguments,
+			})
+		}
+		b.emit("response.output_item.done", map[string]any{"output_index": it.outputIndex, "item": it.item})
+	}
+}
+
+// outputTextPart 构建 output_text 内容片段
+func outputTextPart(text string) map[string]any {
+	return map[string]any{"type": "output_text", "text": text, "annotations": []any{}}
+}
+
+// assistantMessage 把输出项还原为 Anthropic assistant 消息，保存到对话中供后续请求续接
+func (b *responsesBuilder) assistantMessage() AnthropicRequestMessage {
+	var blocks []interface{}
+	for _, item := range b.output {
+		switch item["type"] {
+		case "message":
+			for _, part := range item["content"].([]any) {
+				blocks = append(blocks, map[string]interface{}{"type": "text", "text": part.(map[string]any)["text"]})
+			}
+		case "function_call":
+			input := map[string]interface{}{}
+			json.Unmarshal([]byte(item["arguments"].(string)), &input)
+			blocks = append(blocks, map[string]interface{}{"type": "tool_use", "id": item["call_id"], "name": item["name"], "input": input})
+		}
+	}
+	if len(blocks) == 0 {
+		blocks = append(blocks, map[string]interface{}{"type": "text", "text": ""})
+	}
+	return AnthropicRequestMessage{Role: "assistant", Content: blocks}
+}
+
+// newResponseObject 构建 response 对象，status 为 in_progress、completed、incomplete 或 failed
+func newResponseObject(id string, createdAt int64, req ResponsesRequest, status string) map[string]any {
+	toolChoice := req.ToolChoice
+	if toolChoice == nil {
+		toolChoice = "auto"
+	}
+	tools := req.Tools
+	if tools == nil {
+		tools = []ResponsesTool{}
+	}
+	return map[string]any{
+		"id":                   id,
+		"object":               "response",
+		"created_at":           createdAt,
+		"status":               status,
+		"error":                nil,
+		"incomplete_details":   nil,
+		"instructions":         nilIfEmpty(req.Instructions),
+		"max_output_tokens":    req.MaxOutputTokens,
+		"model":                req.Model,
+		"output":               []any{},
+		"parallel_tool_calls":  req.ParallelToolCalls == nil || *req.ParallelToolCalls,
+		"previous_response_id": nilIfEmpty(req.PreviousResponseId),
+		"store":                req.Store == nil || *req.Store,
+		"temperature":          req.Temperature,
+		"top_p":                req.TopP,
+		"tool_choice":          toolChoice,
+		"tools":                tools,
+		"metadata":             req.Metadata,
+		"usage":                nil,
+	}
+}
+
+// nilIfEmpty 空字符串编码为 null
+func nilIfEmpty(s string) any {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// finishResponse 填入输出和用量，max_tokens 截断时状态为 incomplete
+func finishResponse(resp map[string]any, builder *responsesBuilder, anthropicReq AnthropicRequest, translator *parser.Translator) {
+	resp["output"] = builder.output
+	resp["status"] = "completed"
+	if translator.StopReason() == "max_tokens" {
+		resp["status"] = "incomplete"
+		resp["incomplete_details"] = map[string]any{"reason": "max_output_tokens"}
+	}
+
+	usage := buildUsage(countInputTokens(anthropicReq), translator)
+	input, _ := usage["input_tokens"].(int)
+	output, _ := usage["output_tokens"].(int)
+	resp["usage"] = map[string]any{
+		"input_tokens":          input,
+		"input_tokens_details":  map[string]any{"cached_tokens": usage["cache_read_input_tokens"]},
+		"output_tokens":         output,
+		"output_tokens_details": map[string]any{"reasoning_tokens": 0},
+		"total_tokens":          input + output,
+	}
+}
+
+// handleResponses 处理 POST /v1/responses 以及 GET、DELETE /v1/responses/{id}
+func handleResponses(w http.ResponseWriter, r *http.Request) {
+	if id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/v1/responses"), "/"); id != "" {
+		handleStoredResponse(w, r, id)
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeOpenAIError(w, parser.InvalidRequestError, "只支持POST请求")
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeOpenAIError(w, parser.InvalidRequestError, fmt.Sprintf("读取请求体失败: %v", err))
+		return
+	}
+	defer r.Body.Close()
+
+	var req ResponsesRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeOpenAIError(w, parser.InvalidRequestError, fmt.Sprintf("解析请求体失败: %v", err))
+		return
+	}
+
+	var history []AnthropicRequestMessage
+	if req.PreviousResponseId != "" {
+		previous, ok := conversationStore.Get(req.PreviousResponseId)
+		if !ok {
+			writeOpenAIError(w, parser.NotFoundError, fmt.Sprintf("Previous response with id '%s' not found.", req.PreviousResponseId))
+			return
+		}
+		history = previous.Messages
+	}
+
+	anthropicReq, err := req.toAnthropicRequest(history)
+	if err != nil {
+		writeOpenAIError(w, upstreamErrorType(err), err.Error())
+		return
+	}
+	if _, ok := ModelMap[anthropicReq.Model]; !ok {
+		writeOpenAIError(w, parser.NotFoundError, fmt.Sprintf("model: unknown or unsupported model %q", anthropicReq.Model))
+		return
+	}
+
+	resp := newResponseObject(responseID("resp"), time.Now().Unix(), req, "in_progress")
+	var builder *responsesBuilder
+	if req.Stream {
+		builder = streamResponses(w, resp, anthropicReq)
+	} else {
+		builder = createResponse(w, resp, anthropicReq)
+	}
+	if builder == nil {
+		return
+	}
+
+	if req.Store == nil || *req.Store {
+		conversationStore.Set(resp["id"].(string), &StoredResponse{
+			Response:  resp,
+			Messages:  append(anthropicReq.Messages, builder.assistantMessage()),
+			CreatedAt: time.Now(),
+		})
+	}
+}
+
+// createResponse 处理非流式请求，失败时写出错误并返回 nil
+func createResponse(w http.ResponseWriter, resp map[string]any, anthropicReq AnthropicRequest) *responsesBuilder {
+	respBody, err := upstreamClient.Call(anthropicReq)
+	if err != nil {
+		writeOpenAIError(w, upstreamErrorType(err), err.Error())
+		return nil
+	}
+
+	translator := newTranslator(anthropicReq)
+	events, err := translator.ParseResponse(respBody)
+	if err == nil {
+		err = checkToolChoice(anthropicReq, translator.StopReason())
+	}
+	if err != nil {
+		writeOpenAIError(w, upstreamErrorType(err), err.Error())
+		return nil
+	}
+
+	builder := newResponsesBuilder(nil)
+	for _, e := range events {
+		builder.handle(e)
+	}
+	finishResponse(resp, builder, anthropicReq, translator)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+	return builder
+}
+
+// streamResponses 处理流式请求，按 Responses API 的语义事件输出，失败时发出 response.failed 并返回 nil
+func streamResponses(w http.ResponseWriter, resp map[string]any, anthropicReq AnthropicRequest) *responsesBuilder {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeOpenAIError(w, parser.APIError, "Streaming unsupported!")
+		return nil
+	}
+
+	upstreamResp, err := upstreamClient.Stream(anthropicReq)
+	if err != nil {
+		writeOpenAIError(w, upstreamErrorType(err), err.Error())
+		return nil
+	}
+	defer upstreamResp.Body.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	sequence := 0
+	emit := func(eventType string, data map[string]any) {
+		data["type"] = eventType
+		data["sequence_number"] = sequence
+		sequence++
+		payload, err := json.Marshal(data)
+		if err != nil {
+			return
+		}
+		fmt.Fprintf(w, "event: %s\ndata: %s\n\n", eventType, payload)
+		flusher.Flush()
+	}
+
+	emit("response.created", map[string]any{"response": resp})
+	emit("response.in_progress", map[string]any{"response": resp})
+
+	builder := newResponsesBuilder(emit)
+	translator := newTranslator(anthropicReq)
+	err = translator.Stream(upstreamResp.Body, builder.handle)
+	if err == nil {
+		err = checkToolChoice(anthropicReq, translator.StopReason())
+	}
+	if err != nil {
+		fmt.Printf("错误: 读取 CodeWhisperer 响应流失败: %v\n", err)
+		resp["status"] = "failed"
+		resp["output"] = builder.output
+		resp["error"] = map[string]any{"code": upstreamErrorType(err), "message": err.Error()}
+		emit("response.failed", map[string]any{"response": resp})
+		return nil
+	}
+
+	finishResponse(resp, builder, anthropicReq, translator)
+	if resp["status"] == "incomplete" {
+		emit("response.incomplete", map[string]any{"response": resp})
+	} else {
+		emit("response.completed", map[string]any{"response": resp})
+	}
+	return builder
+}
+
+// handleStoredResponse 处理 GET /v1/responses/{id} 和 DELETE /v1/responses/{id}
+func handleStoredResponse(w http.ResponseWriter, r *http.Request, id string) {
+	switch r.Method {
+	case http.MethodGet:
+		stored, ok := conversationStore.Get(id)
+		if !ok {
+			writeOpenAIError(w, parser.NotFoundError, fmt.Sprintf("Response with id '%s' not found.", id))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(stored.Response)
+	case http.MethodDelete:
+		if !conversationStore.Delete(id) {
+			writeOpenAIError(w, parser.NotFoundError, fmt.Sprintf("Response with id '%s' not found.", id))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"id": id, "object": "response.deleted", "deleted": true})
+	default:
+		writeOpenAIError(w, parser.InvalidRequestError, "只支持GET和DELETE请求")
+	}
+}
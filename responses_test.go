@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestResponsesToAnthropicRequest(t *testing.T) {
+	body := `{
+		"model": "claude-sonnet-4-20250514",
+		"instructions": "Be brief.",
+		"max_output_tokens": 128,
+		"tools": [{"type": "function", "name": "get_weather", "parameters": {"type": "object"}}],
+		"tool_choice": {"type": "function", "name": "get_weather"},
+		"input": [
+			{"role": "developer", "content": "Use metric units."},
+			{"type": "message", "role": "user", "content": [{"type": "input_text", "text": "Weather here?"}]},
+			{"type": "function_call", "call_id": "call_1", "name": "get_weather", "arguments": "{\"city\":\"Paris\"}"},
+			{"type": "function_call_output", "call_id": "call_1", "output": "sunny"}
+		]
+	}`
+	var responsesReq ResponsesRequest
+	if err := json.Unmarshal([]byte(body), &responsesReq); err != nil {
+		t.Fatal(err)
+	}
+	history := []AnthropicRequestMessage{{Role: "user", Content: "earlier"}}
+	req, err := responsesReq.toAnthropicRequest(history)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if req.MaxTokens != 128 || req.System.Text() != "Be brief.\n\nUse metric units." {
+		t.Errorf("max_tokens=%d system=%q", req.MaxTokens, req.System.Text())
+	}
+	if len(req.Tools) != 1 || req.ToolChoice == nil || req.ToolChoice.Type != ToolChoiceTool || req.ToolChoice.Name != "get_weather" {
+		t.Errorf("tools = %+v, tool_choice = %+v", req.Tools, req.ToolChoice)
+	}
+
+	want := []AnthropicRequestMessage{
+		{Role: "user", Content: "earlier"},
+		{Role: "user", Content: []interface{}{
+			map[string]interface{}{"type": "text", "text": "Weather here?"},
+		}},
+		{Role: "assistant", Content: []interface{}{
+			map[string]interface{}{"type": "tool_use", "id": "call_1", "name": "get_weather", "input": map[string]interface{}{"city": "Paris"}},
+		}},
+		{Role: "user", Content: []interface{}{
+			map[string]interface{}{"type": "tool_result", "tool_use_id": "call_1", "content": "sunny"},
+		}},
+	}
+	if !reflect.DeepEqual(req.Messages, want) {
+		t.Errorf("messages:\ngot  %#v\nwant %#v", req.Messages, want)
+	}
+
+	if _, err := (ResponsesRequest{Tools: []ResponsesTool{{Type: "web_search"}}}).toAnthropicRequest(nil); err == nil {
+		t.Error("web_search tool: expected an error")
+	}
+}
+
+// postResponses 发送 /v1/responses 请求并解析非流式响应
+func postResponses(t *testing.T, body string) map[string]any {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	handleResponses(rec, httptest.NewRequest(http.MethodPost, "/v1/responses", strings.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body)
+	}
+	var resp map[string]any
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestResponses(t *testing.T) {
+	withMockUpstream(t)
+
+	first := postResponses(t, `{"model":"claude-sonnet-4-20250514","input":"hello"}`)
+	if first["object"] != "response" || first["status"] != "completed" {
+		t.Fatalf("unexpected response: %v", first)
+	}
+	output := first["output"].([]any)
+	if len(output) != 1 {
+		t.Fatalf("output = %v", output)
+	}
+	message := output[0].(map[string]any)
+	content := message["content"].([]any)[0].(map[string]any)
+	if message["type"] != "message" || content["type"] != "output_text" || content["text"] != "Mock response to: hello" {
+		t.Errorf("output item = %v", message)
+	}
+	usage := first["usage"].(map[string]any)
+	if usage["total_tokens"].(float64) != usage["input_tokens"].(float64)+usage["output_tokens"].(float64) {
+		t.Errorf("usage = %v", usage)
+	}
+
+	// previous_response_id 续接保存的对话
+	id := first["id"].(string)
+	stored, ok := conversationStore.Get(id)
+	if !ok || len(stored.Messages) != 2 || stored.Messages[1].Role != "assistant" {
+		t.Fatalf("stored conversation = %+v", stored)
+	}
+	second := postResponses(t, `{"model":"claude-sonnet-4-20250514","previous_response_id":"`+id+`","input":"again"}`)
+	if second["previous_response_id"] != id {
+		t.Errorf("previous_response_id = %v", second["previous_response_id"])
+	}
+	if stored, _ := conversationStore.Get(second["id"].(string)); len(stored.Messages) != 4 {
+		t.Errorf("continued conversation has %d messages, want 4", len(stored.Messages))
+	}
+
+	rec := httptest.NewRecorder()
+	handleResponses(rec, httptest.NewRequest(http.MethodPost, "/v1/responses", strings.NewReader(`{"model":"claude-sonnet-4-20250514","previous_response_id":"resp_missing","input":"hi"}`)))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("unknown previous_response_id: status = %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	handleResponses(rec, httptest.NewRequest(http.MethodDelete, "/v1/responses/"+id, nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("delete: status = %d", rec.Code)
+	}
+	if _, ok := conversationStore.Get(id); ok {
+		t.Error("response still stored after delete")
+	}
+}
+
+func TestResponsesStream(t *testing.T) {
+	withMockUpstream(t)
+
+	body := `{"model":"claude-sonnet-4-20250514","stream":true,"store":false,
+		"tools":[{"type":"function","name":"Read"}],
+		"input":[{"role":"user","content":"[mock:tool] read it"}]}`
+	rec := httptest.NewRecorder()
+	handleResponses(rec, httptest.NewRequest(http.MethodPost, "/v1/responses", strings.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body)
+	}
+
+	var types []string
+	var arguments string
+	var completed map[string]any
+	scanner := bufio.NewScanner(rec.Body)
+	for scanner.Scan() {
+		line, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		var event map[string]any
+		if err := json.Unmarshal([]byte(line), &event); err != nil {
+			t.Fatalf("bad event %q: %v", line, err)
+		}
+		if int(event["sequence_number"].(float64)) != len(types) {
+			t.Errorf("sequence_number = %v, want %d", event["sequence_number"], len(types))
+		}
+		types = append(types, event["type"].(string))
+		switch event["type"] {
+		case "response.function_call_arguments.delta":
+			arguments += event["delta"].(string)
+		case "response.completed":
+			completed = event["response"].(map[string]any)
+		}
+	}
+
+	if len(types) < 3 || types[0] != "response.created" || types[1] != "response.in_progress" || types[len(types)-1] != "response.completed" {
+		t.Fatalf("event types = %v", types)
+	}
+	if !json.Valid([]byte(arguments)) {
+		t.Errorf("arguments %q are not valid JSON", arguments)
+	}
+
+	var call map[string]any
+	for _, item := range completed["output"].([]any) {
+		if item := item.(map[string]any); item["type"] == "function_call" {
+			call = item
+		}
+	}
+	if call == nil || call["name"] != "Read" || call["arguments"] != arguments || call["status"] != "completed" {
+		t.Errorf("function_call item = %v", call)
+	}
+	if _, ok := conversationStore.Get(completed["id"].(string)); ok {
+		t.Error("store: false response was saved")
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import "fmt"
+
+// 上游模型不支持思考时的处理策略
+const (
+	ThinkingPolicyError   = "error"   // 返回 invalid_request_error
+	ThinkingPolicyDegrade = "degrade" // 忽略 thinking 参数，按普通请求处理
+)
+
+// minThinkingBudget 与 Anthropic API 一致的 budget_tokens 下限
+const minThinkingBudget = 1024
+
+// thinkingModels 支持扩展思考的 CodeWhisperer 模型
+var thinkingModels = map[string]bool{
+	"CLAUDE_SONNET_4_20250514_V1_0":   true,
+	"CLAUDE_3_7_SONNET_20250219_V1_0": true,
+}
+
+// AnthropicThinking 表示请求中的 thinking 配置
+type AnthropicThinking struct {
+	Type         string `json:"type"` // enabled 或 disabled
+	BudgetTokens int    `json:"budget_tokens,omitempty"`
+}
+
+// validateThinking 校验 thinking 参数，并按配置策略处理不支持思考的模型
+func validateThinking(req AnthropicRequest) error {
+	if req.Thinking == nil {
+		return nil
+	}
+
+	switch req.Thinking.Type {
+	case "disabled":
+		return nil
+	case "enabled":
+	default:
+		return &invalidRequestError{fmt.Sprintf("thinking.type: unexpected value %q, expected enabled or disabled", req.Thinking.Type)}
+	}
+
+	if req.Thinking.BudgetTokens < minThinkingBudget {
+		return &invalidRequestError{fmt.Sprintf("thinking.budget_tokens: must be at least %d", minThinkingBudget)}
+	}
+	if req.MaxTokens > 0 && req.Thinking.BudgetTokens >= req.MaxTokens {
+		return &invalidRequestError{"thinking.budget_tokens: must be less than max_tokens"}
+	}
+
+	if !thinkingModels[ModelMap[req.Model]] && config.Thinking.UnsupportedPolicy == ThinkingPolicyError {
+		return &invalidRequestError{fmt.Sprintf("model %q does not support extended thinking", req.Model)}
+	}
+	return nil
+}
+
+// thinkingEnabled 判断本次请求是否真正开启思考；模型不支持且策略为 degrade 时返回 false
+func thinkingEnabled(req AnthropicRequest) bool {
+	return req.Thinking != nil && req.Thinking.Type == "enabled" && thinkingModels[ModelMap[req.Model]]
+}
+
+// thinkingPrompt 返回开启思考时并入 system 提示词的指令
+func thinkingPrompt(req AnthropicRequest) string {
+	return fmt.Sprintf("<thinking_mode>enabled</thinking_mode><max_thinking_length>%d</max_thinking_length>", req.Thinking.BudgetTokens)
+}
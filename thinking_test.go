@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestValidateThinking(t *testing.T) {
+	defer func(policy string) { config.Thinking.UnsupportedPolicy = policy }(config.Thinking.UnsupportedPolicy)
+
+	tests := []struct {
+		name     string
+		model    string
+		thinking *AnthropicThinking
+		policy   string
+		wantErr  bool
+		enabled  bool
+	}{
+		{name: "absent", model: "claude-sonnet-4-20250514"},
+		{name: "disabled", model: "claude-sonnet-4-20250514", thinking: &AnthropicThinking{Type: "disabled"}},
+		{name: "enabled", model: "claude-sonnet-4-20250514", thinking: &AnthropicThinking{Type: "enabled", BudgetTokens: 2048}, enabled: true},
+		{name: "bad type", model: "claude-sonnet-4-20250514", thinking: &AnthropicThinking{Type: "on"}, wantErr: true},
+		{name: "small budget", model: "claude-sonnet-4-20250514", thinking: &AnthropicThinking{Type: "enabled", BudgetTokens: 100}, wantErr: true},
+		{name: "budget over max_tokens", model: "claude-sonnet-4-20250514", thinking: &AnthropicThinking{Type: "enabled", BudgetTokens: 8192}, wantErr: true},
+		{name: "unsupported, degrade", model: "unknown-model", thinking: &AnthropicThinking{Type: "enabled", BudgetTokens: 2048}, policy: ThinkingPolicyDegrade},
+		{name: "unsupported, error", model: "unknown-model", thinking: &AnthropicThinking{Type: "enabled", BudgetTokens: 2048}, policy: ThinkingPolicyError, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		config.Thinking.UnsupportedPolicy = tt.policy
+		req := AnthropicRequest{Model: tt.model, MaxTokens: 4096, Thinking: tt.thinking}
+
+		err := validateThinking(req)
+		var reqErr *invalidRequestError
+		if tt.wantErr != errors.As(err, &reqErr) {
+			t.Errorf("%s: got error %v, want error %v", tt.name, err, tt.wantErr)
+		}
+		if !tt.wantErr && thinkingEnabled(req) != tt.enabled {
+			t.Errorf("%s: thinkingEnabled = %v, want %v", tt.name, !tt.enabled, tt.enabled)
+		}
+	}
+}
+
+func TestBuildAnthropicResponseThinking(t *testing.T) {
+	raw, err := os.ReadFile(filepath.Join("parser", "testdata", "thinking.raw"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := AnthropicRequest{
+		Model:    "claude-sonnet-4-20250514",
+		Messages: []AnthropicRequestMessage{{Role: "user", Content: "hi"}},
+	}
+
+	resp, err := buildAnthropicResponse(req, raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := len(resp["content"].([]map[string]any)); got != 1 {
+		t.Errorf("without thinking: got %d content blocks, want only the text block", got)
+	}
+
+	req.MaxTokens = 4096
+	req.Thinking = &AnthropicThinking{Type: "enabled", BudgetTokens: 1024}
+	resp, err = buildAnthropicResponse(req, raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []map[string]any{
+		{"type": "thinking", "thinking": "The user wants a greeting.", "signature": "sig-1"},
+		{"type": "text", "text": "Hello!"},
+	}
+	if got := resp["content"]; !reflect.DeepEqual(got, want) {
+		t.Errorf("content = %#v, want %#v", got, want)
+	}
+}
+
+func TestThinkingBlocksInHistory(t *testing.T) {
+	req := AnthropicRequest{
+		Model: "claude-sonnet-4-20250514",
+		Messages: []AnthropicRequestMessage{
+			{Role: "user", Content: "read main.go"},
+			{Role: "assistant", Content: []interface{}{
+				map[string]interface{}{"type": "thinking", "thinking": "I should read it.", "signature": "sig-1"},
+				map[string]interface{}{"type": "redacted_thinking", "data": "b3BhcXVl"},
+				map[string]interface{}{"type": "tool_use", "id": "tu_1", "name": "Read", "input": map[string]interface{}{"path": "main.go"}},
+			}},
+			{Role: "user", Content: []interface{}{
+				map[string]interface{}{"type": "tool_result", "tool_use_id": "tu_1", "content": "package main"},
+			}},
+		},
+	}
+
+	cwReq, err := buildCodeWhispererRequest(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assistant := cwReq.ConversationState.History[1].(HistoryAssistantMessage).AssistantResponseMessage
+	if assistant.Content != "" {
+		t.Errorf("assistant content = %q, want thinking left out", assistant.Content)
+	}
+	if len(assistant.ToolUses) != 1 || assistant.ToolUses[0].ToolUseId != "tu_1" {
+		t.Errorf("tool uses = %#v, want tu_1", assistant.ToolUses)
+	}
+}
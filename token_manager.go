@@ -1,11 +1,8 @@
 package main
 
 import (
-	"bytes"
 	"encoding/json"
 	"fmt"
-	"io"
-	"net/http"
 	"os"
 	"sync"
 	"time"
@@ -23,6 +20,11 @@ var tokenManager = &TokenManager{}
 
 // GetToken 获取token，优先从缓存获取
 func (tm *TokenManager) GetToken() (*TokenData, error) {
+	// 模拟上游不校验token，无需本地登录
+	if config.MockUpstream.Enabled {
+		return &TokenData{AccessToken: "mock-access-token", RefreshToken: "mock-refresh-token"}, nil
+	}
+
 	tm.mu.RLock()
 	
 	// 检查缓存是否有效（5分钟内的token认为有效）
@@ -77,7 +79,7 @@ func (tm *TokenManager) refreshTokenAsync() {
 	tm.mu.Lock()
 	defer tm.mu.Unlock()
 
-	if tm.cachedToken == nil {
+	if tm.cachedToken == nil || config.MockUpstream.Enabled {
 		return
 	}
 
@@ -97,38 +99,12 @@ func (tm *TokenManager) refreshTokenAsync() {
 
 // performTokenRefresh 执行实际的token刷新
 func (tm *TokenManager) performTokenRefresh(refreshToken string) (*TokenData, error) {
-	// 这里复用原有的refreshToken逻辑，但返回TokenData而不是直接写文件
-	refreshReq := RefreshRequest{
-		RefreshToken: refreshToken,
-	}
-
-	reqBody, err := json.Marshal(refreshReq)
+	newToken, err := upstreamClient.RefreshToken(refreshToken)
 	if err != nil {
-		return nil, fmt.Errorf("序列化请求失败: %v", err)
-	}
-
-	resp, err := http.Post(
-		"https://prod.us-east-1.auth.desktop.kiro.dev/refreshToken",
-		"application/json",
-		bytes.NewBuffer(reqBody),
-	)
-	if err != nil {
-		return nil, fmt.Errorf("刷新token请求失败: %v", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("刷新token失败，状态码: %d, 响应: %s", resp.StatusCode, string(body))
-	}
-
-	var refreshResp RefreshResponse
-	if err := json.NewDecoder(resp.Body).Decode(&refreshResp); err != nil {
-		return nil, fmt.Errorf("解析刷新响应失败: %v", err)
+		return nil, err
 	}
 
 	// 保存到文件
-	newToken := TokenData(refreshResp)
 	tokenPath := getTokenFilePath()
 	newData, err := json.MarshalIndent(newToken, "", "  ")
 	if err != nil {
@@ -139,7 +115,7 @@ func (tm *TokenManager) performTokenRefresh(refreshToken string) (*TokenData, er
 		return nil, fmt.Errorf("写入token文件失败: %v", err)
 	}
 
-	return &newToken, nil
+	return newToken, nil
 }
 
 // InvalidateToken 使缓存的token失效
@@ -0,0 +1,141 @@
+// Package tokenizer estimates Claude token counts offline.
+//
+// Claude's tokenizer is not public, so this package approximates it with a
+// byte-pair encoding trained on a mix of English prose and source code. It
+// splits text into words the way GPT-style tokenizers do, then merges the
+// characters of each word following the embedded vocabulary. Counts track
+// Claude's closely enough for usage reporting and max_tokens accounting;
+// they are not exact.
+package tokenizer
+
+import (
+	"bufio"
+	_ "embed"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// vocab lists the merged tokens, one quoted string per line, in the order
+// the merges were learned. A token's rank is its line number; single
+// characters are implicit and always tokens on their own.
+//
+//go:embed vocab.txt
+var vocab string
+
+// wordPattern splits text into the words BPE runs on: contractions, letter
+// runs, up to three digits, punctuation runs and whitespace, each letter,
+// number or punctuation run taking one leading space.
+var wordPattern = regexp.MustCompile(`'(?:[sdmt]|ll|ve|re)| ?\p{L}+| ?\p{N}{1,3}| ?[^\s\p{L}\p{N}]+|\s+`)
+
+// maxCacheSize bounds the per-word cache; it is cleared when full.
+const maxCacheSize = 1 << 16
+
+var (
+	loadOnce sync.Once
+	ranks    map[string]int
+
+	cacheMu sync.Mutex
+	cache   = map[string]int{}
+)
+
+func load() {
+	ranks = make(map[string]int, strings.Count(vocab, "\n"))
+	scanner := bufio.NewScanner(strings.NewReader(vocab))
+	for scanner.Scan() {
+		token, err := strconv.Unquote(scanner.Text())
+		if err != nil {
+			panic("tokenizer: bad vocabulary entry " + scanner.Text())
+		}
+		if _, ok := ranks[token]; !ok {
+			ranks[token] = len(ranks)
+		}
+	}
+}
+
+// Count returns the estimated number of tokens in text.
+func Count(text string) int {
+	if text == "" {
+		return 0
+	}
+	loadOnce.Do(load)
+
+	n := 0
+	for _, word := range words(text) {
+		n += countWord(word)
+	}
+	return n
+}
+
+// Encode splits text into its estimated tokens. It is mostly useful for
+// inspecting how a piece of text is counted.
+func Encode(text string) []string {
+	loadOnce.Do(load)
+
+	var tokens []string
+	for _, word := range words(text) {
+		tokens = append(tokens, merge(word)...)
+	}
+	return tokens
+}
+
+// words splits text with wordPattern. Like the "\s+(?!\S)" rule of GPT
+// tokenizers, a whitespace run gives its last space to the word after it,
+// so indented code tokenizes as indentation plus " word".
+func words(text string) []string {
+	pieces := wordPattern.FindAllString(text, -1)
+	for i := 0; i+1 < len(pieces); i++ {
+		p, next := pieces[i], pieces[i+1]
+		if len(p) > 1 && strings.HasSuffix(p, " ") && strings.TrimSpace(p) == "" &&
+			!strings.HasPrefix(next, " ") && strings.TrimSpace(next) != "" {
+			pieces[i] = p[:len(p)-1]
+			pieces[i+1] = " " + next
+		}
+	}
+	return pieces
+}
+
+func countWord(word string) int {
+	cacheMu.Lock()
+	n, ok := cache[word]
+	cacheMu.Unlock()
+	if ok {
+		return n
+	}
+
+	n = len(merge(word))
+
+	cacheMu.Lock()
+	if len(cache) >= maxCacheSize {
+		cache = map[string]int{}
+	}
+	cache[word] = n
+	cacheMu.Unlock()
+	return n
+}
+
+// merge applies BPE to a single word: starting from its characters, it
+// repeatedly joins the adjacent pair whose concatenation has the lowest
+// rank until no pair is in the vocabulary.
+func merge(word string) []string {
+	var parts []string
+	for _, r := range word {
+		parts = append(parts, string(r))
+	}
+
+	for len(parts) > 1 {
+		best, bestRank := -1, len(ranks)
+		for i := 0; i+1 < len(parts); i++ {
+			if rank, ok := ranks[parts[i]+parts[i+1]]; ok && rank < bestRank {
+				best, bestRank = i, rank
+			}
+		}
+		if best < 0 {
+			break
+		}
+		parts[best] += parts[best+1]
+		parts = append(parts[:best+1], parts[best+2:]...)
+	}
+	return parts
+}
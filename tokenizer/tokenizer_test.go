@@ -0,0 +1,82 @@
+package tokenizer
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+var samples = []string{
+	"",
+	"Hello, world!",
+	"The quick brown fox jumps over the lazy dog.",
+	"func main() {\n\tfmt.Println(\"hi\")\n}\n",
+	"    indented := true\n        deeper()",
+	"I'll say it's 1234567 o'clock",
+	"混合 English 和中文",
+	"emoji 🎉 and tabs\t\there",
+}
+
+func TestEncodeRoundTrip(t *testing.T) {
+	for _, s := range samples {
+		tokens := Encode(s)
+		if got := strings.Join(tokens, ""); got != s {
+			t.Errorf("Encode(%q) joins back to %q", s, got)
+		}
+		if got := Count(s); got != len(tokens) {
+			t.Errorf("Count(%q) = %d, Encode gives %d tokens", s, got, len(tokens))
+		}
+	}
+}
+
+func TestCountMerges(t *testing.T) {
+	for _, s := range samples[1:] {
+		if n := Count(s); n <= 0 || n >= utf8.RuneCountInString(s) {
+			t.Errorf("Count(%q) = %d, want between 1 and %d", s, n, utf8.RuneCountInString(s)-1)
+		}
+	}
+
+	// Common words are single tokens with their leading space.
+	for _, word := range []string{" the", " function", " return", " error"} {
+		if tokens := Encode(word); len(tokens) != 1 {
+			t.Errorf("Encode(%q) = %q, want one token", word, tokens)
+		}
+	}
+}
+
+func TestCountCJK(t *testing.T) {
+	// Characters outside the vocabulary count as one token each, not one
+	// per UTF-8 byte.
+	if got := Count("你好世界"); got != 4 {
+		t.Errorf("Count(CJK) = %d, want 4", got)
+	}
+}
+
+func TestWords(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"hello world", []string{"hello", " world"}},
+		{"    return x", []string{"   ", " return", " x"}},
+		{"a\n\tb", []string{"a", "\n\t", "b"}},
+		{"don't stop", []string{"don", "'t", " stop"}},
+		{"x = 12345", []string{"x", " =", " 123", "45"}},
+		{"end  ", []string{"end", "  "}},
+	}
+
+	for _, tt := range tests {
+		if got := words(tt.text); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("words(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func BenchmarkCount(b *testing.B) {
+	text := strings.Repeat(samples[2]+" "+samples[3], 100)
+	b.SetBytes(int64(len(text)))
+	for i := 0; i < b.N; i++ {
+		Count(text)
+	}
+}
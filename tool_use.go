@@ -0,0 +1,219 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// CodeWhispererToolUse 表示助手消息中的一次工具调用
+type CodeWhispererToolUse struct {
+	ToolUseId string `json:"toolUseId"`
+	Name      string `json:"name"`
+	Input     any    `json:"input"`
+}
+
+// CodeWhispererToolResult 表示用户消息中的工具执行结果
+type CodeWhispererToolResult struct {
+	ToolUseId string                           `json:"toolUseId"`
+	Content   []CodeWhispererToolResultContent `json:"content"`
+	Status    string                           `json:"status"` // success 或 error
+}
+
+// CodeWhispererToolResultContent 表示工具结果中的一段内容
+type CodeWhispererToolResultContent struct {
+	Text string `json:"text"`
+}
+
+// UserInputMessageContext 表示 userInputMessage 的上下文，当前消息和历史消息共用
+type UserInputMessageContext struct {
+	ToolResults []CodeWhispererToolResult `json:"toolResults,omitempty"`
+	Tools       []CodeWhispererTool       `json:"tools,omitempty"`
+}
+
+// getContentBlocks 将 []ContentBlock 形式的消息内容解析为内容块，字符串内容返回 nil
+func getContentBlocks(content any) []ContentBlock {
+	raw, ok := content.([]interface{})
+	if !ok {
+		return nil
+	}
+
+	var blocks []ContentBlock
+	for _, block := range raw {
+		data, err := json.Marshal(block)
+		if err != nil {
+			continue
+		}
+		var cb ContentBlock
+		if err := json.Unmarshal(data, &cb); err != nil {
+			continue
+		}
+		blocks = append(blocks, cb)
+	}
+	return blocks
+}
+
+// getUserInputContent 提取发往 CodeWhisperer 的用户文本。
+// tool_result 和 image 块走结构化字段，不再拼进文本
+func getUserInputContent(content any) string {
+	blocks := getContentBlocks(content)
+	if blocks == nil {
+		return getMessageContent(content)
+	}
+
+	var texts []string
+	structured := false
+	for _, cb := range blocks {
+		switch cb.Type {
+		case "text":
+			if cb.Text != nil {
+				texts = append(texts, *cb.Text)
+			}
+		case "tool_result", "image":
+			structured = true
+		}
+	}
+	if len(texts) == 0 && !structured {
+		return getMessageContent(content)
+	}
+	return strings.Join(texts, "\n")
+}
+
+// getAssistantContent 提取助手消息的文本，tool_use 块走 toolUses 字段。
+// CodeWhisperer 的历史消息没有思考内容字段，thinking 和 redacted_thinking 块不会转发
+func getAssistantContent(content any) string {
+	blocks := getContentBlocks(content)
+	if blocks == nil {
+		return getMessageContent(content)
+	}
+
+	var texts []string
+	structured := false
+	for _, cb := range blocks {
+		switch cb.Type {
+		case "text":
+			if cb.Text != nil {
+				texts = append(texts, *cb.Text)
+			}
+		case "tool_use", "thinking", "redacted_thinking":
+			structured = true
+		}
+	}
+	if len(texts) == 0 && !structured {
+		return getMessageContent(content)
+	}
+	return strings.Join(texts, "\n")
+}
+
+// getToolUses 提取助手消息中的 tool_use 块，没有时返回空切片
+func getToolUses(content any) []CodeWhispererToolUse {
+	toolUses := make([]CodeWhispererToolUse, 0)
+	for _, cb := range getContentBlocks(content) {
+		if cb.Type != "tool_use" || cb.Id == nil || cb.Name == nil {
+			continue
+		}
+		toolUse := CodeWhispererToolUse{
+			ToolUseId: *cb.Id,
+			Name:      *cb.Name,
+			Input:     map[string]any{},
+		}
+		if cb.Input != nil && *cb.Input != nil {
+			toolUse.Input = *cb.Input
+		}
+		toolUses = append(toolUses, toolUse)
+	}
+	return toolUses
+}
+
+// getToolResults 提取用户消息中的 tool_result 块，is_error 映射为 error 状态
+func getToolResults(content any) []CodeWhispererToolResult {
+	var results []CodeWhispererToolResult
+	for _, cb := range getContentBlocks(content) {
+		if cb.Type != "tool_result" || cb.ToolUseId == nil {
+			continue
+		}
+		status := "success"
+		if cb.IsError != nil && *cb.IsError {
+			status = "error"
+		}
+		results = append(results, CodeWhispererToolResult{
+			ToolUseId: *cb.ToolUseId,
+			Content:   []CodeWhispererToolResultContent{{Text: toolResultText(cb.Content)}},
+			Status:    status,
+		})
+	}
+	return results
+}
+
+// toolResultText 将 tool_result 的 content 展开为文本，content 可以是 string 或内容块数组
+func toolResultText(content any) string {
+	switch v := content.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	default:
+		var texts []string
+		for _, cb := range getContentBlocks(v) {
+			switch cb.Type {
+			case "text":
+				if cb.Text != nil {
+					texts = append(texts, *cb.Text)
+				}
+			case "image":
+				texts = append(texts, "[image]")
+			}
+		}
+		return strings.Join(texts, "\n")
+	}
+}
+
+// toolCallIDs 为不带调用 id 的客户端格式（Ollama、Gemini）生成 tool_use id，
+// 并把随后的工具结果对应回调用：优先按工具名，否则按调用顺序
+type toolCallIDs struct {
+	count   int
+	pending []pendingToolCall
+}
+
+// pendingToolCall 表示一次尚未收到结果的工具调用
+type pendingToolCall struct {
+	id, name string
+}
+
+// reset 开始一条新的助手消息，之前没有结果的调用不再参与匹配
+func (t *toolCallIDs) reset() {
+	t.pending = nil
+}
+
+// add 记录一次调用并返回其 id，客户端没有给出 id 时按顺序生成
+func (t *toolCallIDs) add(id, name string) string {
+	t.count++
+	if id == "" {
+		id = fmt.Sprintf("call_%d", t.count)
+	}
+	t.pending = append(t.pending, pendingToolCall{id, name})
+	return id
+}
+
+// match 返回工具结果对应的调用 id，id 不为空时直接使用；没有可对应的调用时返回 false
+func (t *toolCallIDs) match(id, name string) (string, bool) {
+	found := -1
+	for i, call := range t.pending {
+		if (id != "" && call.id == id) || (id == "" && name != "" && call.name == name) {
+			found = i
+			break
+		}
+	}
+	if found < 0 {
+		if id != "" {
+			return id, true
+		}
+		if len(t.pending) == 0 {
+			return "", false
+		}
+		found = 0
+	}
+	id = t.pending[found].id
+	t.pending = append(t.pending[:found], t.pending[found+1:]...)
+	return id, true
+}
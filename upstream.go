@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/bestk/kiro2cc/parser"
+)
+
+// UpstreamClient 统一封装对CodeWhisperer和Kiro认证服务的请求。
+// 端点、区域、认证地址和profile ARN都从Config读取，所有请求路径都应经过这里。
+type UpstreamClient struct{}
+
+var upstreamClient = &UpstreamClient{}
+
+// Region 返回上游所在区域
+func (uc *UpstreamClient) Region() string {
+	if config.API.Region == "" {
+		return "us-east-1"
+	}
+	return config.API.Region
+}
+
+// Endpoint 返回generateAssistantResponse地址。启用模拟上游时指向本地服务，
+// 未显式配置时按区域拼出官方地址
+func (uc *UpstreamClient) Endpoint() string {
+	if config.MockUpstream.Enabled {
+		return config.MockUpstream.URL
+	}
+	if config.API.CodeWhispererURL != "" {
+		return config.API.CodeWhispererURL
+	}
+	return fmt.Sprintf("https://codewhisperer.%s.amazonaws.com/generateAssistantResponse", uc.Region())
+}
+
+// AuthURL 返回token刷新地址
+func (uc *UpstreamClient) AuthURL() string {
+	if config.API.KiroAuthURL != "" {
+		return config.API.KiroAuthURL
+	}
+	return fmt.Sprintf("https://prod.%s.auth.desktop.kiro.dev/refreshToken", uc.Region())
+}
+
+// ProfileArn 返回请求体中携带的profile ARN
+func (uc *UpstreamClient) ProfileArn() string {
+	return config.API.ProfileArn
+}
+
+// proxy 为上游请求选择出口代理，未配置时沿用环境变量
+func (uc *UpstreamClient) proxy(req *http.Request) (*url.URL, error) {
+	if config.API.ProxyURL != "" {
+		return url.Parse(config.API.ProxyURL)
+	}
+	return http.ProxyFromEnvironment(req)
+}
+
+// GenerateAssistantResponse 发送CodeWhisperer请求并返回原始响应，调用方负责关闭Body
+func (uc *UpstreamClient) GenerateAssistantResponse(accessToken string, cwReq CodeWhispererRequest, streaming bool) (*http.Response, error) {
+	cwReqBody, err := json.Marshal(cwReq)
+	if err != nil {
+		return nil, fmt.Errorf("序列化请求失败: %v", err)
+	}
+
+	httpReq, err := http.NewRequest(http.MethodPost, uc.Endpoint(), bytes.NewBuffer(cwReqBody))
+	if err != nil {
+		return nil, fmt.Errorf("创建代理请求失败: %v", err)
+	}
+
+	httpReq.Header.Set("Authorization", "Bearer "+accessToken)
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	client := httpClientManager.GetClient()
+	if streaming {
+		httpReq.Header.Set("Accept", "text/event-stream")
+		client = httpClientManager.GetStreamingClient()
+	}
+
+	return client.Do(httpReq)
+}
+
+// Call 用当前token发送非流式请求并读取完整响应，非200状态转换为*parser.UpstreamError
+func (uc *UpstreamClient) Call(req AnthropicRequest) ([]byte, error) {
+	token, err := tokenManager.GetToken()
+	if err != nil {
+		return nil, err
+	}
+
+	cwReq, err := buildCodeWhispererRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := uc.GenerateAssistantResponse(token.AccessToken, cwReq, false)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		if resp.StatusCode == http.StatusForbidden {
+			go tokenManager.refreshTokenAsync()
+		}
+		return nil, parser.NewHTTPError(resp.StatusCode, respBody)
+	}
+
+	return respBody, nil
+}
+
+// Stream 用当前token发送流式请求并返回响应，调用方负责关闭Body。
+// 非200状态转换为*parser.UpstreamError，403时异步刷新token
+func (uc *UpstreamClient) Stream(req AnthropicRequest) (*http.Response, error) {
+	token, err := tokenManager.GetToken()
+	if err != nil {
+		return nil, err
+	}
+
+	cwReq, err := buildCodeWhispererRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := uc.GenerateAssistantResponse(token.AccessToken, cwReq, true)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode == http.StatusForbidden {
+			go tokenManager.refreshTokenAsync()
+		}
+		return nil, parser.NewHTTPError(resp.StatusCode, body)
+	}
+
+	return resp, nil
+}
+
+// RefreshToken 用refresh token换取新的token，不写入文件
+func (uc *UpstreamClient) RefreshToken(refreshToken string) (*TokenData, error) {
+	reqBody, err := json.Marshal(RefreshRequest{RefreshToken: refreshToken})
+	if err != nil {
+		return nil, fmt.Errorf("序列化请求失败: %v", err)
+	}
+
+	resp, err := httpClientManager.GetClient().Post(uc.AuthURL(), "application/json", bytes.NewBuffer(reqBody))
+	if err != nil {
+		return nil, fmt.Errorf("刷新token请求失败: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("刷新token失败，状态码: %d, 响应: %s", resp.StatusCode, string(body))
+	}
+
+	var refreshResp RefreshResponse
+	if err := json.NewDecoder(resp.Body).Decode(&refreshResp); err != nil {
+		return nil, fmt.Errorf("解析刷新响应失败: %v", err)
+	}
+
+	newToken := TokenData(refreshResp)
+	return &newToken, nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+
+	"github.com/bestk/kiro2cc/parser"
+	"github.com/bestk/kiro2cc/tokenizer"
+)
+
+const (
+	// messageOverheadTokens 每条消息的角色和分隔符开销
+	messageOverheadTokens = 4
+	// toolUseSystemTokens 带工具的请求额外注入的系统提示词，数值取自 Anthropic 文档
+	toolUseSystemTokens       = 346 // tool_choice 为 auto 或 none
+	toolUseSystemTokensForced = 313 // tool_choice 为 any 或 tool
+	// maxImageTokens 单张图片的 token 上限，超过约 1.15MP 的图片会被缩小
+	maxImageTokens = 1600
+	// imagePixelsPerToken Anthropic 文档给出的图片换算比例 tokens = 宽 * 高 / 750
+	imagePixelsPerToken = 750
+)
+
+// countInputTokens 用 tokenizer 估算请求的输入 token 数，覆盖 system、全部消息和工具定义
+func countInputTokens(req AnthropicRequest) int {
+	n := tokenizer.Count(req.System.Text())
+
+	for _, msg := range req.Messages {
+		n += messageOverheadTokens + countContentTokens(msg.Content)
+	}
+
+	if len(req.Tools) > 0 {
+		if req.ToolChoice != nil && (req.ToolChoice.Type == ToolChoiceAny || req.ToolChoice.Type == ToolChoiceTool) {
+			n += toolUseSystemTokensForced
+		} else {
+			n += toolUseSystemTokens
+		}
+		for _, tool := range req.Tools {
+			schema, _ := json.Marshal(tool.InputSchema)
+			n += tokenizer.Count(tool.Name) + tokenizer.Count(tool.Description) + tokenizer.Count(string(schema))
+		}
+	}
+	return n
+}
+
+// countContentTokens 估算一条消息内容的 token 数，content 可以是 string 或内容块数组
+func countContentTokens(content any) int {
+	blocks := getContentBlocks(content)
+	if blocks == nil {
+		return tokenizer.Count(getMessageContent(content))
+	}
+
+	n := 0
+	for _, cb := range blocks {
+		switch cb.Type {
+		case "text":
+			if cb.Text != nil {
+				n += tokenizer.Count(*cb.Text)
+			}
+		case "tool_use":
+			if cb.Name != nil {
+				n += tokenizer.Count(*cb.Name)
+			}
+			if cb.Input != nil {
+				input, _ := json.Marshal(*cb.Input)
+				n += tokenizer.Count(string(input))
+			}
+		case "tool_result":
+			n += tokenizer.Count(toolResultText(cb.Content))
+		case "image":
+			n += countImageTokens(cb.Source)
+		}
+	}
+	return n
+}
+
+// countImageTokens 按图片尺寸估算 token 数，无法读取尺寸时（url 图片、webp）按上限计
+func countImageTokens(source *ImageSource) int {
+	if source == nil || source.Type != "base64" {
+		return maxImageTokens
+	}
+	data, err := base64.StdEncoding.DecodeString(source.Data)
+	if err != nil {
+		return maxImageTokens
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return maxImageTokens
+	}
+	return min(cfg.Width*cfg.Height/imagePixelsPerToken+1, maxImageTokens)
+}
+
+// buildUsage 构建响应中的 usage 对象。上游报告了用量时以上游为准，
+// 否则使用 tokenizer 的估算；上游没有缓存信息时缓存字段为 0
+func buildUsage(inputTokens int, translator *parser.Translator) map[string]any {
+	usage := map[string]any{
+		"input_tokens":                inputTokens,
+		"output_tokens":               translator.OutputTokens(),
+		"cache_creation_input_tokens": 0,
+		"cache_read_input_tokens":     0,
+	}
+	if translator.Usage != nil && translator.Usage.TokenUsage != nil {
+		u := translator.Usage.TokenUsage
+		usage["input_tokens"] = u.UncachedInputTokens
+		usage["output_tokens"] = u.OutputTokens
+		usage["cache_creation_input_tokens"] = u.CacheWriteInputTokens
+		usage["cache_read_input_tokens"] = u.CacheReadInputTokens
+	}
+	return usage
+}
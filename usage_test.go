@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/png"
+	"testing"
+
+	"github.com/bestk/kiro2cc/parser"
+	"github.com/bestk/kiro2cc/tokenizer"
+)
+
+func TestCountInputTokens(t *testing.T) {
+	base := AnthropicRequest{
+		Messages: []AnthropicRequestMessage{{Role: "user", Content: "What is in this repository?"}},
+	}
+	n := countInputTokens(base)
+	if want := messageOverheadTokens + tokenizer.Count("What is in this repository?"); n != want {
+		t.Errorf("single message = %d, want %d", n, want)
+	}
+
+	withSystem := base
+	withSystem.System = AnthropicSystem{{Type: "text", Text: "You are a helpful assistant."}}
+	if got := countInputTokens(withSystem); got <= n {
+		t.Errorf("system prompt not counted: %d <= %d", got, n)
+	}
+
+	withHistory := base
+	withHistory.Messages = []AnthropicRequestMessage{
+		{Role: "user", Content: "Read main.go"},
+		{Role: "assistant", Content: []interface{}{
+			map[string]interface{}{"type": "tool_use", "id": "tu_1", "name": "Read", "input": map[string]interface{}{"path": "main.go"}},
+		}},
+		{Role: "user", Content: []interface{}{
+			map[string]interface{}{"type": "tool_result", "tool_use_id": "tu_1", "content": "package main"},
+		}},
+	}
+	if got := countInputTokens(withHistory); got <= 3*messageOverheadTokens+tokenizer.Count("Read main.go") {
+		t.Errorf("tool_use and tool_result not counted: %d", got)
+	}
+
+	withTools := base
+	withTools.Tools = []AnthropicTool{{Name: "Read", Description: "Read a file", InputSchema: map[string]any{"type": "object"}}}
+	if got := countInputTokens(withTools); got <= n+toolUseSystemTokens {
+		t.Errorf("tools not counted: %d <= %d", got, n+toolUseSystemTokens)
+	}
+}
+
+func TestCountImageTokens(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 300, 250))); err != nil {
+		t.Fatal(err)
+	}
+	source := &ImageSource{Type: "base64", MediaType: "image/png", Data: base64.StdEncoding.EncodeToString(buf.Bytes())}
+
+	if got, want := countImageTokens(source), 300*250/imagePixelsPerToken+1; got != want {
+		t.Errorf("300x250 image = %d tokens, want %d", got, want)
+	}
+	if got := countImageTokens(&ImageSource{Type: "url", URL: "https://example.com/a.png"}); got != maxImageTokens {
+		t.Errorf("url image = %d tokens, want %d", got, maxImageTokens)
+	}
+}
+
+func TestBuildUsage(t *testing.T) {
+	tr := parser.NewTranslator()
+	tr.CountTokens = tokenizer.Count
+	if _, err := tr.Translate(&parser.AssistantResponseEvent{Content: "Hello, world!"}); err != nil {
+		t.Fatal(err)
+	}
+
+	usage := buildUsage(42, tr)
+	want := map[string]any{
+		"input_tokens":                42,
+		"output_tokens":               tokenizer.Count("Hello, world!"),
+		"cache_creation_input_tokens": 0,
+		"cache_read_input_tokens":     0,
+	}
+	for k, v := range want {
+		if usage[k] != v {
+			t.Errorf("usage[%q] = %v, want %v", k, usage[k], v)
+		}
+	}
+}